@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,6 +18,7 @@ import (
 	"github.com/hurricanerix/http-helper/middleware"
 	"github.com/hurricanerix/http-helper/platforms/python"
 	"github.com/hurricanerix/http-helper/platforms/s3"
+	"github.com/hurricanerix/http-helper/platforms/sigv4"
 	"github.com/joho/godotenv"
 )
 
@@ -24,13 +27,28 @@ func init() {
 }
 
 const defaultServerPipeline = "logger, error, request_id, bandwidth, ttfb, cors, mime, etag"
+const defaultS3ServerPipeline = "logger, request_id, s3.error, bandwidth, ttfb"
+const defaultS3AuthServerPipeline = "logger, request_id, s3.error, s3.auth, bandwidth, ttfb"
+const defaultSigV4ServerPipeline = "logger, error, request_id, bandwidth, ttfb"
 const defaultServerHandler = "python"
 
+const defaultS3Domain = s3.DefaultDomain
+const defaultS3LifecycleInterval = time.Minute
+
+const defaultSigV4Upstream = "http://127.0.0.1:9000"
+const defaultSigV4Service = "s3"
+const defaultSigV4Region = "us-east-1"
+
 const defaultServerIdleTimeout = 5 * time.Second
 const defaultServerReadTimeout = 5 * time.Second
 const defaultServerWriteTimeout = 5 * time.Second
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		presign(os.Args[2:])
+		return
+	}
+
 	address := flag.String("bind", "127.0.0.1", "Bind to this address.")
 	port := flag.Int("port", 8000, "Bind to this port.")
 	directory := flag.String("d", ".", "Serve this directory.")
@@ -39,6 +57,7 @@ func main() {
 
 	flag.Usage = func() {
 		fmt.Printf("Usage: %s [FLAGS]\n", os.Args[0])
+		fmt.Printf("       %s presign [FLAGS]\n", os.Args[0])
 		fmt.Println("")
 		fmt.Println("Build Info:")
 		fmt.Println("  Built with:", build.GoVersion())
@@ -72,8 +91,12 @@ func main() {
 		panic(err)
 	}
 
-	p := getPipeline(config.StringEnv("HH_SERVER_PIPELINE", defaultServerPipeline))
-	h := getHandler(config.StringEnv("HH_SERVER_HANDLER", defaultServerHandler), directoryAbsolutePath)
+	handlerName := config.StringEnv("HH_SERVER_HANDLER", defaultServerHandler)
+	p := getPipeline(config.StringEnv("HH_SERVER_PIPELINE", getDefaultPipeline(handlerName)))
+	h := getHandler(handlerName, directoryAbsolutePath)
+	if s3Handler, ok := h.(s3.Handler); ok {
+		go s3Handler.RunLifecycle(context.Background(), config.DurationEnv("HH_S3_LIFECYCLE_INTERVAL", defaultS3LifecycleInterval))
+	}
 	s := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", *address, *port),
 		Handler:      wrap(h, p),
@@ -99,8 +122,30 @@ func wrap(h http.Handler, p pipeline) http.Handler {
 func getHandler(name string, dir string) http.Handler {
 	switch name {
 	case "s3":
-		return s3.Handler{
-			Directory: dir,
+		h := s3.Handler{
+			Directory:        dir,
+			Domain:           config.StringEnv("HH_S3_DOMAIN", defaultS3Domain),
+			NotificationURL:  config.StringEnv("HH_S3_NOTIFICATION_URL", ""),
+			NotificationFile: config.StringEnv("HH_S3_NOTIFICATION_FILE", ""),
+		}
+		if config.BoolEnv("HH_S3_CLOCK", false) {
+			h.Clock = &s3.Clock{}
+			h.Clock.Advance(config.DurationEnv("HH_S3_CLOCK_OFFSET", 0))
+		}
+		return h
+	case "sigv4":
+		rawUpstream := config.StringEnv("HH_SIGV4_UPSTREAM", defaultSigV4Upstream)
+		upstream, err := url.Parse(rawUpstream)
+		if err != nil || upstream.Host == "" {
+			log.Fatalf("invalid HH_SIGV4_UPSTREAM %q", rawUpstream)
+		}
+		return sigv4.Handler{
+			Upstream:     upstream,
+			Service:      config.StringEnv("HH_SIGV4_SERVICE", defaultSigV4Service),
+			Region:       config.StringEnv("HH_SIGV4_REGION", config.StringEnv("AWS_REGION", defaultSigV4Region)),
+			AccessKeyID:  config.StringEnv("HH_SIGV4_ACCESS_KEY_ID", config.StringEnv("AWS_ACCESS_KEY_ID", "")),
+			Secret:       config.StringEnv("HH_SIGV4_SECRET_ACCESS_KEY", config.StringEnv("AWS_SECRET_ACCESS_KEY", "")),
+			SessionToken: config.StringEnv("HH_SIGV4_SESSION_TOKEN", config.StringEnv("AWS_SESSION_TOKEN", "")),
 		}
 	case "python":
 		fallthrough
@@ -111,6 +156,25 @@ func getHandler(name string, dir string) http.Handler {
 	}
 }
 
+// getDefaultPipeline returns the pipeline used when HH_SERVER_PIPELINE is not
+// set.  The s3 handler returns the ETag and Content-Type stored with each
+// object, and CORS rules configured per bucket, so the etag, mime and cors
+// stages are omitted.  Panics are reported as S3 error responses by s3.error,
+// and requests are verified by s3.auth when access keys are configured.
+// The sigv4 proxy returns the headers of its upstream unchanged.
+func getDefaultPipeline(handlerName string) string {
+	switch handlerName {
+	case "s3":
+		if len(s3.AccessKeys()) > 0 {
+			return defaultS3AuthServerPipeline
+		}
+		return defaultS3ServerPipeline
+	case "sigv4":
+		return defaultSigV4ServerPipeline
+	}
+	return defaultServerPipeline
+}
+
 func getPipeline(rawPipeline string) pipeline {
 	stageNames := strings.Split(strings.ReplaceAll(rawPipeline, " ", ""), ",")
 
@@ -142,6 +206,10 @@ func getStage(stageName string) stage {
 		return middleware.ETag
 	case "python.logger":
 		return python.Logger
+	case "s3.auth":
+		return s3.Auth
+	case "s3.error":
+		return s3.Error
 	}
 
 	return middleware.NOP
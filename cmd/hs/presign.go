@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hurricanerix/http-helper/config"
+	"github.com/hurricanerix/http-helper/platforms/s3"
+)
+
+const defaultS3Host = "127.0.0.1:8000"
+const defaultS3Region = "us-east-1"
+const defaultS3PresignExpires = 15 * time.Minute
+
+// presign prints a presigned URL for an object served by the s3 handler.
+// Flags default to the HH_S3_* env variables, with the access key and secret
+// taken from the first access key s3.auth accepts, configured in
+// HH_S3_CREDENTIALS or HH_S3_CREDENTIALS_FILE.
+func presign(args []string) {
+	var accessKeyID, secret string
+	if keys := s3.AccessKeys(); len(keys) > 0 {
+		accessKeyID, secret = keys[0].ID, keys[0].Secret
+	}
+
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	method := flags.String("method", http.MethodGet, "Sign the URL for this HTTP method.")
+	bucket := flags.String("bucket", config.StringEnv("HH_S3_BUCKET", ""), "Sign the URL for this bucket.")
+	key := flags.String("key", "", "Sign the URL for this object key.")
+	expires := flags.Duration("expires", config.DurationEnv("HH_S3_PRESIGN_EXPIRES", defaultS3PresignExpires), "Expire the URL after this long, at most 7 days.")
+	region := flags.String("region", config.StringEnv("HH_S3_REGION", defaultS3Region), "Sign the URL for this region.")
+	host := flags.String("host", config.StringEnv("HH_S3_HOST", defaultS3Host), "Sign the URL for this host and port.")
+	scheme := flags.String("scheme", config.StringEnv("HH_S3_SCHEME", "http"), "Use this URL scheme.")
+	flags.StringVar(&accessKeyID, "access-key-id", accessKeyID, "Sign the URL with this access key ID.")
+	flags.StringVar(&secret, "secret", secret, "Sign the URL with this secret access key.")
+	sessionToken := flags.String("session-token", config.StringEnv("HH_S3_SESSION_TOKEN", ""), "Sign the URL with this session token of temporary credentials.")
+	curl := flags.Bool("curl", false, "Print a curl command for the URL.")
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s presign [FLAGS]\n", os.Args[0])
+		fmt.Println("")
+		fmt.Println("Flags:")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if *key == "" || accessKeyID == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -key and an access key are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *expires <= 0 || *expires > 7*24*time.Hour {
+		fmt.Fprintln(os.Stderr, "ERROR: -expires must be between 1s and 7 days")
+		os.Exit(2)
+	}
+
+	signer := s3.S3{
+		AccessID:     accessKeyID,
+		AWSRegion:    *region,
+		Expires:      int(expires.Seconds()),
+		Host:         *host,
+		Secret:       secret,
+		BucketName:   *bucket,
+		Scheme:       *scheme,
+		SessionToken: *sessionToken,
+	}
+	url, err := signer.SignURL(strings.ToUpper(*method), strings.TrimPrefix(*key, "/"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+
+	if *curl {
+		fmt.Println(curlCommand(strings.ToUpper(*method), url))
+		return
+	}
+	fmt.Println(url)
+}
+
+// curlCommand returns a curl command requesting url with method.  Bodies of
+// PUT requests are read from stdin.
+func curlCommand(method, url string) string {
+	quoted := "'" + strings.ReplaceAll(url, "'", `'\''`) + "'"
+	switch method {
+	case http.MethodGet:
+		return "curl " + quoted
+	case http.MethodHead:
+		return "curl -I " + quoted
+	case http.MethodPut:
+		return "curl -T - " + quoted
+	default:
+		return "curl -X " + method + " " + quoted
+	}
+}
@@ -1,17 +1,356 @@
 package s3
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/hurricanerix/http-helper/config"
 )
 
+// maxRequestSkew is how far the time a request was signed may differ from
+// the current time.
+const maxRequestSkew = 15 * time.Minute
+
+// maxPresignExpires is the longest a presigned URL may be valid for.
+const maxPresignExpires = 7 * 24 * 60 * 60
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Auth verifies requests are signed with AWS Signature Version 4 by one of
+// the access keys loaded by loadCredentials, or temporary credentials issued
+// by STS with their X-Amz-Security-Token, either in the Authorization header
+// or as a presigned URL.  Requests whose signatures can not be verified are
+// rejected with an S3 error.  HH_S3_DOMAIN must match the Domain of the
+// Handler, so requests to a virtual-hosted bucket are not mistaken for STS.
+//
+// Requests which are not signed at all are passed on as anonymous, for
+// Handler to deny unless a bucket policy allows them, so Auth must only be
+// used in front of Handler.
 func Auth(h http.Handler) http.Handler {
+	creds := loadCredentials()
+	domain := config.StringEnv("HH_S3_DOMAIN", DefaultDomain)
 	fn := func(rw http.ResponseWriter, r *http.Request) {
-		id := strings.ReplaceAll(uuid.New().String(), "-", "")
-		rw.Header().Add("X-Request-ID", id)
-		h.ServeHTTP(rw, r)
+		setRequestID(rw)
+
+		// Browsers do not sign CORS preflight requests.
+		if r.Method == http.MethodOptions {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		// POST uploads are signed by the policy in the form, which is
+		// verified by the handler as the form is read.
+		if isPostObject(r) && !r.URL.Query().Has("delete") {
+			r = r.WithContext(context.WithValue(r.Context(), credentialsKey{}, creds))
+			h.ServeHTTP(rw, withPrincipal(r, principal{}))
+			return
+		}
+
+		if r.Header.Get("Authorization") == "" && !r.URL.Query().Has("X-Amz-Algorithm") {
+			h.ServeHTTP(rw, withPrincipal(r, principal{}))
+			return
+		}
+
+		session, err := creds.verify(r, domain)
+		if err != nil {
+			apiErr := apiError{}
+			if !errors.As(err, &apiErr) {
+				apiErr = errAccessDenied
+			}
+			if isSTSRequest(r, domain) {
+				writeSTSError(rw, apiErr)
+				return
+			}
+			writeError(rw, r, apiErr)
+			return
+		}
+
+		// STS issues temporary credentials sealed with the secret of the
+		// access key which requested them.
+		if isSTSRequest(r, domain) {
+			r = r.WithContext(context.WithValue(r.Context(), credentialsKey{}, creds))
+		}
+		auth, _ := requestCredential(r)
+		h.ServeHTTP(rw, withPrincipal(r, principal{AccessKeyID: auth.AccessKeyID, Session: session}))
 	}
 	return http.HandlerFunc(fn)
 }
+
+// authorization holds the fields of a SigV4 signature, parsed from either
+// the Authorization header or the query parameters of a presigned URL.
+type authorization struct {
+	AccessKeyID   string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+}
+
+// Scope returns the credential scope the request was signed for.
+func (a authorization) Scope() string {
+	return strings.Join([]string{a.Date, a.Region, a.Service, "aws4_request"}, "/")
+}
+
+// verify verifies the signature of r, returning the STS session of the
+// temporary credentials it was signed with, if any.
+func (c credentials) verify(r *http.Request, domain string) (*session, error) {
+	if r.URL.Query().Has("X-Amz-Algorithm") {
+		return c.verifyQuery(r)
+	}
+	return c.verifyHeader(r, domain)
+}
+
+// verifyHeader verifies a request signed using the Authorization header.
+func (c credentials) verifyHeader(r *http.Request, domain string) (*session, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, errAccessDenied
+	}
+
+	auth, err := parseAuthorization(header)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Service != requestService(r, domain) {
+		return nil, errAuthorizationHeaderMalformed
+	}
+
+	secret, session, err := c.secret(auth.AccessKeyID, r.Header.Get("X-Amz-Security-Token"))
+	if err != nil {
+		return nil, err
+	}
+
+	amzDate, err := requestDate(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(amzDate, auth.Date) {
+		return nil, errAuthorizationHeaderMalformed
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		// Only s3 requires the payload hash to be sent.
+		if auth.Service == awsService {
+			return nil, errMissingContentSHA256
+		}
+		if payloadHash, err = hashBody(r); err != nil {
+			return nil, err
+		}
+	}
+
+	canonicalRequest := requestCanonicalRequest(r, canonicalQuery(r.URL.Query()), auth.SignedHeaders, payloadHash)
+	if err := auth.verifySignature(secret, amzDate, canonicalRequest); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case sha256Pattern.MatchString(payloadHash):
+		r.Body = &sha256Reader{
+			ReadCloser: r.Body,
+			hash:       sha256.New(),
+			want:       payloadHash,
+		}
+	case payloadHash == streamingPayload, payloadHash == streamingPayloadTrailer:
+		body, err := newChunkedReader(r.Body, r.Header, &chunkSigner{
+			key:      getSigningKey(secret, auth.Date, auth.Region, auth.Service),
+			amzDate:  amzDate,
+			scope:    auth.Scope(),
+			previous: auth.Signature,
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	return session, nil
+}
+
+// verifyQuery verifies a presigned request, signed using the X-Amz-*
+// query parameters.
+func (c credentials) verifyQuery(r *http.Request) (*session, error) {
+	query := r.URL.Query()
+
+	if query.Get("X-Amz-Algorithm") != amzAlgorithm {
+		return nil, errUnsupportedAuthorization
+	}
+
+	auth := authorization{}
+	if err := auth.parseCredential(query.Get("X-Amz-Credential")); err != nil || auth.Service != awsService {
+		return nil, errAuthorizationQueryParametersError
+	}
+
+	auth.Signature = query.Get("X-Amz-Signature")
+	if query.Get("X-Amz-SignedHeaders") == "" || auth.Signature == "" {
+		return nil, errAuthorizationQueryParametersError
+	}
+	auth.SignedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+
+	amzDate := query.Get("X-Amz-Date")
+	signedAt, err := time.Parse(dateTimeLayout, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, auth.Date) {
+		return nil, errAuthorizationQueryParametersError
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxPresignExpires {
+		return nil, errAuthorizationQueryParametersError
+	}
+
+	secret, session, err := c.secret(auth.AccessKeyID, query.Get("X-Amz-Security-Token"))
+	if err != nil {
+		return nil, err
+	}
+
+	now := timeNow()
+	if now.Before(signedAt.Add(-maxRequestSkew)) {
+		return nil, errRequestNotValidYet
+	}
+	if now.After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		return nil, errRequestExpired
+	}
+
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+
+	canonicalRequest := requestCanonicalRequest(r, canonicalQuery(query, "X-Amz-Signature"), auth.SignedHeaders, payloadHash)
+	return session, auth.verifySignature(secret, amzDate, canonicalRequest)
+}
+
+// verifySignature compares the signature provided by the client to the one
+// computed for the canonical request.
+func (a authorization) verifySignature(secret, amzDate, canonicalRequest string) error {
+	stringToSign := getStringToSign(amzDate, a.Scope(), canonicalRequest)
+	signature := sign(stringToSign, secret, a.Date, a.Region, a.Service)
+	if hmac.Equal([]byte(signature), []byte(a.Signature)) {
+		return nil
+	}
+
+	err := errSignatureDoesNotMatch
+	err.AWSAccessKeyID = a.AccessKeyID
+	err.StringToSign = stringToSign
+	err.CanonicalRequest = canonicalRequest
+	return err
+}
+
+// parseAuthorization parses an Authorization header of the form:
+//
+//	AWS4-HMAC-SHA256 Credential=<id>/<date>/<region>/<service>/aws4_request, SignedHeaders=<headers>, Signature=<signature>
+func parseAuthorization(header string) (authorization, error) {
+	auth := authorization{}
+
+	algorithm, fields, _ := strings.Cut(header, " ")
+	if algorithm != amzAlgorithm {
+		return auth, errUnsupportedAuthorization
+	}
+
+	values := map[string]string{}
+	for _, field := range strings.Split(fields, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return auth, errAuthorizationHeaderMalformed
+		}
+		values[key] = value
+	}
+
+	if err := auth.parseCredential(values["Credential"]); err != nil {
+		return auth, err
+	}
+
+	auth.Signature = values["Signature"]
+	if values["SignedHeaders"] == "" || auth.Signature == "" {
+		return auth, errAuthorizationHeaderMalformed
+	}
+	auth.SignedHeaders = strings.Split(values["SignedHeaders"], ";")
+
+	return auth, nil
+}
+
+// requestCredential returns the credential a request claims to be signed
+// with, in either the Authorization header or the query string, without
+// verifying the signature.
+func requestCredential(r *http.Request) (authorization, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		auth, err := parseAuthorization(header)
+		return auth, err == nil
+	}
+	auth := authorization{}
+	err := auth.parseCredential(r.URL.Query().Get("X-Amz-Credential"))
+	return auth, err == nil
+}
+
+// parseCredential parses a credential of the form
+// <id>/<date>/<region>/<service>/aws4_request.
+func (a *authorization) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[0] == "" {
+		return errAuthorizationHeaderMalformed
+	}
+	if _, err := time.Parse("20060102", parts[1]); err != nil {
+		return errAuthorizationHeaderMalformed
+	}
+	if parts[3] != awsService && parts[3] != stsService {
+		return errAuthorizationHeaderMalformed
+	}
+
+	a.AccessKeyID = parts[0]
+	a.Date = parts[1]
+	a.Region = parts[2]
+	a.Service = parts[3]
+	return nil
+}
+
+// requestDate returns the time a request was signed in the SigV4 date time
+// layout, rejecting requests too far from the current time.
+func requestDate(r *http.Request) (string, error) {
+	var t time.Time
+	var err error
+	if v := r.Header.Get("X-Amz-Date"); v != "" {
+		t, err = time.Parse(dateTimeLayout, v)
+	} else if v := r.Header.Get("Date"); v != "" {
+		t, err = http.ParseTime(v)
+	} else {
+		return "", errMissingDateHeader
+	}
+	if err != nil {
+		return "", errMissingDateHeader
+	}
+
+	if skew := timeNow().Sub(t); skew > maxRequestSkew || skew < -maxRequestSkew {
+		return "", errRequestTimeTooSkewed
+	}
+
+	return t.UTC().Format(dateTimeLayout), nil
+}
+
+// sha256Reader verifies the data read matches the SHA256 the client signed.
+type sha256Reader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+}
+
+func (s *sha256Reader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(s.hash.Sum(nil)) != s.want {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"os"
+)
+
+func (h Handler) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	io.Copy(io.Discard, r.Body)
+
+	if h.bucketExists(bucket) {
+		writeError(w, r, errBucketAlreadyOwnedByYou)
+		return
+	}
+
+	if err := os.MkdirAll(h.bucketPath(bucket), 0755); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.bucketExists(bucket) {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.bucketExists(bucket) {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+
+	entries, err := os.ReadDir(h.bucketPath(bucket))
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	if len(entries) != 0 {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+
+	// Buckets can not be deleted while noncurrent versions or delete
+	// markers remain.
+	if versions, err := os.ReadDir(h.systemPath(bucket, "versions")); err == nil && len(versions) != 0 {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+
+	if err := os.Remove(h.bucketPath(bucket)); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	os.RemoveAll(h.systemPath(bucket))
+
+	w.WriteHeader(http.StatusNoContent)
+}
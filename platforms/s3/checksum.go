@@ -0,0 +1,226 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// checksumAlgorithm is an algorithm which can be used for the
+// x-amz-checksum-* headers.
+type checksumAlgorithm struct {
+	Name string
+	New  func() hash.Hash
+}
+
+// crc64NVMETable is the table for the CRC-64/NVME polynomial, in the
+// reversed form used by hash/crc64.
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+var checksumAlgorithms = []checksumAlgorithm{
+	{Name: "CRC32", New: func() hash.Hash { return crc32.NewIEEE() }},
+	{Name: "CRC32C", New: func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+	{Name: "CRC64NVME", New: func() hash.Hash { return crc64.New(crc64NVMETable) }},
+	{Name: "SHA1", New: sha1.New},
+	{Name: "SHA256", New: sha256.New},
+}
+
+// checksum is a digest sent with a request, to be compared with the digest
+// of the body received.
+type checksum struct {
+	// Name is MD5, for the Content-MD5 header, or the name of the
+	// algorithm of an x-amz-checksum-* header.
+	Name     string
+	Expected []byte
+	Hash     hash.Hash
+}
+
+// checksums are the digests sent with a request.  Writing the body to it
+// computes the digests to verify.
+type checksums []checksum
+
+// newChecksums returns the checksums from the Content-MD5 and
+// x-amz-checksum-* headers of a request.
+func newChecksums(header http.Header) (checksums, error) {
+	c := checksums{}
+
+	if v := header.Get("Content-MD5"); v != "" {
+		expected, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(expected) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		c = append(c, checksum{Name: "MD5", Expected: expected, Hash: md5.New()})
+	}
+
+	found := false
+	for _, alg := range checksumAlgorithms {
+		name := "X-Amz-Checksum-" + alg.Name
+		v := header.Get(name)
+		if v == "" {
+			continue
+		}
+		if found {
+			return nil, errMultipleChecksums
+		}
+		found = true
+
+		h := alg.New()
+		expected, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(expected) != h.Size() {
+			err := errInvalidChecksum
+			err.Message = "Value for " + strings.ToLower(name) + " header is invalid."
+			return nil, err
+		}
+		c = append(c, checksum{Name: alg.Name, Expected: expected, Hash: h})
+	}
+
+	return c, nil
+}
+
+// findChecksumAlgorithm returns the algorithm with the given name, which is
+// not case sensitive.
+func findChecksumAlgorithm(name string) (checksumAlgorithm, bool) {
+	for _, alg := range checksumAlgorithms {
+		if strings.EqualFold(alg.Name, name) {
+			return alg, true
+		}
+	}
+	return checksumAlgorithm{}, false
+}
+
+func (c checksums) Write(p []byte) (int, error) {
+	for _, v := range c {
+		v.Hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify returns a BadDigest error if the digest of the data written does
+// not match one of the checksums.  Checksums without an expected value are
+// only computed.
+func (c checksums) verify() error {
+	for _, v := range c {
+		if v.Expected == nil || bytes.Equal(v.Hash.Sum(nil), v.Expected) {
+			continue
+		}
+		if v.Name == "MD5" {
+			return errBadDigest
+		}
+		err := errBadDigest
+		err.Message = "The " + v.Name + " you specified did not match the calculated checksum."
+		return err
+	}
+	return nil
+}
+
+// checksumReader verifies the checksums of a request body as it is read,
+// returning an error in place of io.EOF if they do not match, and computes
+// the x-amz-checksum-* value stored with the object.
+type checksumReader struct {
+	r    io.Reader
+	sums checksums
+	// algorithm is the name of the algorithm of the stored checksum, if
+	// any.
+	algorithm string
+}
+
+// newChecksumReader returns a reader verifying the checksums sent in header
+// as body is read.  The stored checksum uses the algorithm of the
+// x-amz-checksum-* header or trailer, or the one named by the
+// x-amz-checksum-algorithm header, defaulting to algorithm.
+func newChecksumReader(body io.Reader, header http.Header, algorithm string) (*checksumReader, error) {
+	sums, err := newChecksums(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// The algorithm of the checksum sent, in a header or the trailer.
+	sent := ""
+	if trailer := strings.ToLower(header.Get("X-Amz-Trailer")); strings.HasPrefix(trailer, "x-amz-checksum-") {
+		sent = strings.ToUpper(strings.TrimPrefix(trailer, "x-amz-checksum-"))
+	}
+	for _, v := range sums {
+		if v.Name != "MD5" {
+			sent = v.Name
+		}
+	}
+
+	name := header.Get("X-Amz-Checksum-Algorithm")
+	if name == "" {
+		name = header.Get("X-Amz-Sdk-Checksum-Algorithm")
+	}
+	if name == "" {
+		name = algorithm
+	}
+	name = strings.ToUpper(name)
+	if algorithm != "" && name != algorithm {
+		return nil, checksumTypeMismatch(algorithm, name)
+	}
+	if sent != "" {
+		if name != "" && name != sent {
+			return nil, checksumTypeMismatch(name, sent)
+		}
+		name = sent
+	}
+
+	c := &checksumReader{r: body, sums: sums, algorithm: name}
+	if name == "" || slices.ContainsFunc(sums, func(v checksum) bool { return v.Name == name }) {
+		return c, nil
+	}
+	alg, ok := findChecksumAlgorithm(name)
+	if !ok {
+		return nil, errUnsupportedChecksumAlgorithm
+	}
+	c.sums = append(c.sums, checksum{Name: alg.Name, Hash: alg.New()})
+	return c, nil
+}
+
+func checksumTypeMismatch(expected, actual string) apiError {
+	err := errChecksumTypeMismatch
+	err.Message = fmt.Sprintf(err.Message, strings.ToLower(expected), strings.ToLower(actual))
+	return err
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.sums.Write(p[:n])
+	if err == io.EOF {
+		if verifyErr := c.sums.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+// checksum returns the base64 encoded checksum of the data read, using the
+// stored algorithm, or an empty string if there is none.
+func (c *checksumReader) checksum() string {
+	for _, v := range c.sums {
+		if v.Name == c.algorithm {
+			return base64.StdEncoding.EncodeToString(v.Hash.Sum(nil))
+		}
+	}
+	return ""
+}
+
+// writeChecksumHeaders sets the x-amz-checksum-* header for a checksum
+// computed with algorithm, if there is one.
+func writeChecksumHeaders(header http.Header, algorithm, value, checksumType string) {
+	if algorithm == "" || value == "" {
+		return
+	}
+	header.Set("X-Amz-Checksum-"+algorithm, value)
+	if checksumType != "" {
+		header.Set("X-Amz-Checksum-Type", checksumType)
+	}
+}
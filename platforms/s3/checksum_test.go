@@ -0,0 +1,291 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	// Check values for "123456789" from the catalogue of CRC algorithms.
+	tests := map[string]struct {
+		header  string
+		hex     string
+		body    string
+		wantErr error
+	}{
+		"md5":            {header: "Content-MD5", hex: "25f9e794323b453885f5181f1b624d0b", body: "123456789"},
+		"crc32":          {header: "X-Amz-Checksum-Crc32", hex: "cbf43926", body: "123456789"},
+		"crc32c":         {header: "X-Amz-Checksum-Crc32c", hex: "e3069283", body: "123456789"},
+		"crc64nvme":      {header: "X-Amz-Checksum-Crc64nvme", hex: "ae8b14860a799888", body: "123456789"},
+		"sha1":           {header: "X-Amz-Checksum-Sha1", hex: "f7c3bc1d808e04732adf679965ccc34ca7ae3441", body: "123456789"},
+		"sha256":         {header: "X-Amz-Checksum-Sha256", hex: "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", body: "123456789"},
+		"md5 mismatch":   {header: "Content-MD5", hex: "25f9e794323b453885f5181f1b624d0b", body: "12345678", wantErr: errBadDigest},
+		"crc32 mismatch": {header: "X-Amz-Checksum-Crc32", hex: "cbf43926", body: "12345678", wantErr: apiError{Code: "BadDigest"}},
+		"md5 invalid":    {header: "Content-MD5", hex: "25f9", body: "123456789", wantErr: errInvalidDigest},
+		"sha256 invalid": {header: "X-Amz-Checksum-Sha256", hex: "cbf43926", body: "123456789", wantErr: apiError{Code: "InvalidRequest"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			digest, _ := hex.DecodeString(tc.hex)
+			header := http.Header{}
+			header.Set(tc.header, base64.StdEncoding.EncodeToString(digest))
+
+			sums, err := newChecksums(header)
+			if err == nil {
+				sums.Write([]byte(tc.body))
+				err = sums.verify()
+			}
+
+			got := apiError{}
+			errors.As(err, &got)
+			want := apiError{}
+			errors.As(tc.wantErr, &want)
+			if got.Code != want.Code {
+				t.Errorf("expected err to be %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Checksum-Crc32", "y/Q5Jg==")
+	header.Set("X-Amz-Checksum-Sha1", "98O8HYCOBHMq32eZZczDTKeuNEE=")
+	if _, err := newChecksums(header); !errors.Is(err, errMultipleChecksums) {
+		t.Errorf("expected err to be %v got %v", errMultipleChecksums, err)
+	}
+}
+
+// checksumHeader returns the base64 encoded digest of s computed with the
+// named algorithm.
+func checksumHeader(name, s string) string {
+	alg, _ := findChecksumAlgorithm(name)
+	h := alg.New()
+	h.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func TestPutObjectChecksum(t *testing.T) {
+	tests := map[string]struct {
+		headers      []string
+		wantCode     int
+		wantBody     string
+		wantChecksum []string
+	}{
+		"crc32": {
+			headers:      []string{"X-Amz-Checksum-Crc32", checksumHeader("CRC32", "hello world")},
+			wantCode:     http.StatusOK,
+			wantChecksum: []string{"X-Amz-Checksum-Crc32", checksumHeader("CRC32", "hello world")},
+		},
+		"crc64nvme": {
+			headers:      []string{"X-Amz-Checksum-Crc64nvme", checksumHeader("CRC64NVME", "hello world")},
+			wantCode:     http.StatusOK,
+			wantChecksum: []string{"X-Amz-Checksum-Crc64nvme", checksumHeader("CRC64NVME", "hello world")},
+		},
+		"algorithm only": {
+			headers:      []string{"X-Amz-Checksum-Algorithm", "sha256"},
+			wantCode:     http.StatusOK,
+			wantChecksum: []string{"X-Amz-Checksum-Sha256", checksumHeader("SHA256", "hello world")},
+		},
+		"md5": {
+			headers:  []string{"Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww=="},
+			wantCode: http.StatusOK,
+		},
+		"md5 mismatch": {
+			headers:  []string{"Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg=="},
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>BadDigest</Code>",
+		},
+		"sha1 mismatch": {
+			headers:  []string{"X-Amz-Checksum-Sha1", checksumHeader("SHA1", "hello there")},
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Message>The SHA1 you specified did not match the calculated checksum.</Message>",
+		},
+		"unsupported algorithm": {
+			headers:  []string{"X-Amz-Checksum-Algorithm", "MD4"},
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>InvalidRequest</Code>",
+		},
+		"algorithm mismatch": {
+			headers:  []string{"X-Amz-Checksum-Algorithm", "CRC32", "X-Amz-Checksum-Sha1", checksumHeader("SHA1", "hello world")},
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Message>Checksum Type mismatch occurred, expected checksum Type: crc32, actual checksum Type: sha1</Message>",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := doRequest(h, http.MethodPut, "/bucket/key", "hello world", tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+			if res.Code != http.StatusOK {
+				if res := doRequest(h, http.MethodHead, "/bucket/key", ""); res.Code != http.StatusNotFound {
+					t.Errorf("expected rejected object to not be stored got %d", res.Code)
+				}
+				return
+			}
+
+			res = doRequest(h, http.MethodHead, "/bucket/key", "", "X-Amz-Checksum-Mode", "ENABLED")
+			for _, alg := range checksumAlgorithms {
+				name := "X-Amz-Checksum-" + alg.Name
+				want := ""
+				if len(tc.wantChecksum) == 2 && http.CanonicalHeaderKey(name) == tc.wantChecksum[0] {
+					want = tc.wantChecksum[1]
+				}
+				if got := res.Header().Get(name); got != want {
+					t.Errorf("expected %s to be %q got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetObjectChecksumMode(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	sum := checksumHeader("CRC32C", "hello world")
+	doRequest(h, http.MethodPut, "/bucket/key", "hello world", "X-Amz-Checksum-Crc32c", sum)
+
+	tests := map[string]struct {
+		headers  []string
+		wantSum  string
+		wantType string
+	}{
+		"enabled":  {headers: []string{"X-Amz-Checksum-Mode", "ENABLED"}, wantSum: sum, wantType: "FULL_OBJECT"},
+		"disabled": {},
+		"range":    {headers: []string{"X-Amz-Checksum-Mode", "ENABLED", "Range", "bytes=0-4"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, http.MethodGet, "/bucket/key", "", tc.headers...)
+			if got := res.Header().Get("X-Amz-Checksum-Crc32c"); got != tc.wantSum {
+				t.Errorf("expected checksum to be %q got %q", tc.wantSum, got)
+			}
+			if got := res.Header().Get("X-Amz-Checksum-Type"); got != tc.wantType {
+				t.Errorf("expected checksum type to be %q got %q", tc.wantType, got)
+			}
+		})
+	}
+}
+
+func TestStreamingPutObjectChecksum(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	res := doRequest(h, http.MethodPut, "/bucket/key", unsignedTrailerBody(crc32Trailer("hello world"), "hello ", "world"),
+		"Content-Encoding", "aws-chunked",
+		"X-Amz-Content-Sha256", streamingUnsignedPayloadTrailer,
+		"X-Amz-Decoded-Content-Length", "11",
+		"X-Amz-Trailer", "x-amz-checksum-crc32")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodHead, "/bucket/key", "", "X-Amz-Checksum-Mode", "ENABLED")
+	if want, got := checksumHeader("CRC32", "hello world"), res.Header().Get("X-Amz-Checksum-Crc32"); got != want {
+		t.Errorf("expected checksum to be %q got %q", want, got)
+	}
+}
+
+func TestMultipartUploadChecksum(t *testing.T) {
+	part1 := strings.Repeat("a", minPartSize)
+	part2 := "tail"
+
+	composite := sha256.New()
+	for _, p := range []string{part1, part2} {
+		sum := sha256.Sum256([]byte(p))
+		composite.Write(sum[:])
+	}
+
+	tests := map[string]struct {
+		algorithm    string
+		checksumType string
+		wantHeader   string
+		wantSum      string
+		wantType     string
+	}{
+		"composite": {
+			algorithm:  "SHA256",
+			wantHeader: "X-Amz-Checksum-Sha256",
+			wantSum:    base64.StdEncoding.EncodeToString(composite.Sum(nil)) + "-2",
+			wantType:   "COMPOSITE",
+		},
+		"full object": {
+			algorithm:    "CRC32",
+			checksumType: "FULL_OBJECT",
+			wantHeader:   "X-Amz-Checksum-Crc32",
+			wantSum:      checksumHeader("CRC32", part1+part2),
+			wantType:     "FULL_OBJECT",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			headers := []string{"X-Amz-Checksum-Algorithm", tc.algorithm}
+			if tc.checksumType != "" {
+				headers = append(headers, "X-Amz-Checksum-Type", tc.checksumType)
+			}
+			res := doRequest(h, http.MethodPost, "/bucket/key?uploads", "", headers...)
+			result := initiateMultipartUploadResult{}
+			if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+
+			etags := []string{}
+			for i, p := range []string{part1, part2} {
+				res := doRequest(h, http.MethodPut, fmt.Sprintf("/bucket/key?partNumber=%d&uploadId=%s", i+1, result.UploadID), p)
+				if res.Code != http.StatusOK {
+					t.Fatalf("expected part status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+				}
+				if want, got := checksumHeader(tc.algorithm, p), res.Header().Get(tc.wantHeader); got != want {
+					t.Errorf("expected part checksum to be %q got %q", want, got)
+				}
+				etags = append(etags, res.Header().Get("ETag"))
+			}
+
+			res = doRequest(h, http.MethodPost, "/bucket/key?uploadId="+result.UploadID, completeBody(etags...))
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected complete status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+			}
+
+			res = doRequest(h, http.MethodHead, "/bucket/key", "", "X-Amz-Checksum-Mode", "ENABLED")
+			if got := res.Header().Get(tc.wantHeader); got != tc.wantSum {
+				t.Errorf("expected checksum to be %q got %q", tc.wantSum, got)
+			}
+			if got := res.Header().Get("X-Amz-Checksum-Type"); got != tc.wantType {
+				t.Errorf("expected checksum type to be %q got %q", tc.wantType, got)
+			}
+		})
+	}
+
+	h := newTestHandler(t, "bucket")
+	res := doRequest(h, http.MethodPost, "/bucket/key?uploads", "", "X-Amz-Checksum-Algorithm", "SHA1", "X-Amz-Checksum-Type", "FULL_OBJECT")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected code to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+
+	uploadID := createUpload(t, h, "/bucket/key")
+	res = doRequest(h, http.MethodPut, "/bucket/key?partNumber=1&uploadId="+uploadID, "data", "X-Amz-Checksum-Sha1", checksumHeader("SHA1", "data"))
+	if res.Code != http.StatusOK {
+		t.Errorf("expected code to be %d got %d", http.StatusOK, res.Code)
+	}
+
+	// Parts must use the checksum algorithm of the upload.
+	res = doRequest(h, http.MethodPost, "/bucket/other?uploads", "", "X-Amz-Checksum-Algorithm", "CRC32")
+	result := initiateMultipartUploadResult{}
+	xml.Unmarshal(res.Body.Bytes(), &result)
+	res = doRequest(h, http.MethodPut, "/bucket/other?partNumber=1&uploadId="+result.UploadID, "data", "X-Amz-Checksum-Sha1", checksumHeader("SHA1", "data"))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected code to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+}
@@ -0,0 +1,294 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The x-amz-content-sha256 values of requests whose bodies are sent using
+// the aws-chunked content encoding.
+const (
+	streamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// maxChunkLineLength limits the length of chunk headers and trailers.
+const maxChunkLineLength = 4096
+
+// isStreamingPayload reports whether the body of a request is aws-chunked
+// encoded.
+func isStreamingPayload(header http.Header) bool {
+	switch header.Get("X-Amz-Content-Sha256") {
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedPayloadTrailer:
+		return true
+	}
+	return false
+}
+
+// payloadLength returns the length of the data in the body of r.  For
+// aws-chunked bodies this is X-Amz-Decoded-Content-Length, as the
+// Content-Length includes the chunk headers and signatures.
+func payloadLength(r *http.Request) int64 {
+	if !isStreamingPayload(r.Header) {
+		return r.ContentLength
+	}
+	n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// decodeStreamingBody replaces an aws-chunked encoded request body with the
+// decoded data, unless s3.auth already has, and removes aws-chunked from
+// the Content-Encoding stored with the object.
+func decodeStreamingBody(r *http.Request) error {
+	if !isStreamingPayload(r.Header) {
+		return nil
+	}
+
+	if _, ok := r.Body.(*chunkedReader); !ok {
+		body, err := newChunkedReader(r.Body, r.Header, nil)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+	}
+
+	encodings := []string{}
+	for _, e := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if e = strings.TrimSpace(e); e != "" && e != "aws-chunked" {
+			encodings = append(encodings, e)
+		}
+	}
+	if len(encodings) == 0 {
+		r.Header.Del("Content-Encoding")
+	} else {
+		r.Header.Set("Content-Encoding", strings.Join(encodings, ","))
+	}
+	return nil
+}
+
+// chunkSigner verifies the signature of each chunk, and of the trailer, of
+// a signed aws-chunked body.  Each signature covers the previous one,
+// starting with the signature of the request.
+type chunkSigner struct {
+	key      []byte
+	amzDate  string
+	scope    string
+	previous string
+}
+
+// verify compares signature with the one computed for the next chunk, or
+// trailer, hashed as contentHash.
+func (s *chunkSigner) verify(algorithm, signature string, contentHash ...string) error {
+	stringToSign := strings.Join(append([]string{algorithm, s.amzDate, s.scope, s.previous}, contentHash...), "\n")
+	want := hex.EncodeToString(hmacSHA256(s.key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		err := errSignatureDoesNotMatch
+		err.StringToSign = stringToSign
+		return err
+	}
+	s.previous = signature
+	return nil
+}
+
+// chunkedReader decodes an aws-chunked body, verifying the signatures of
+// each chunk when signer is set, and the trailing checksum, if any.
+type chunkedReader struct {
+	body   io.ReadCloser
+	r      *bufio.Reader
+	signer *chunkSigner
+
+	// trailer is the lower case name of the trailing checksum header.
+	trailer string
+	sums    checksums
+
+	decodedLength int64
+	read          int64
+
+	remaining int64
+	chunkHash hash.Hash
+	chunkSig  string
+	started   bool
+	err       error
+}
+
+// newChunkedReader returns a reader decoding body, an aws-chunked body sent
+// with header.
+func newChunkedReader(body io.ReadCloser, header http.Header, signer *chunkSigner) (*chunkedReader, error) {
+	c := &chunkedReader{
+		body:          body,
+		r:             bufio.NewReaderSize(body, maxChunkLineLength),
+		signer:        signer,
+		decodedLength: -1,
+		chunkHash:     sha256.New(),
+	}
+
+	if v := header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errInvalidArgument
+		}
+		c.decodedLength = n
+	}
+
+	if c.trailer = strings.ToLower(header.Get("X-Amz-Trailer")); c.trailer != "" {
+		for _, alg := range checksumAlgorithms {
+			if c.trailer == "x-amz-checksum-"+strings.ToLower(alg.Name) {
+				c.sums = checksums{{Name: alg.Name, Hash: alg.New()}}
+			}
+		}
+		if c.sums == nil {
+			return nil, errInvalidTrailer
+		}
+	}
+
+	return c, nil
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	n, err := c.r.Read(p[:min(int64(len(p)), c.remaining)])
+	c.remaining -= int64(n)
+	c.read += int64(n)
+	c.chunkHash.Write(p[:n])
+	c.sums.Write(p[:n])
+	if err == io.EOF {
+		err = errIncompleteBody
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+// nextChunk finishes the current chunk and reads the header of the next,
+// verifying the trailer and returning io.EOF after the final chunk.
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if err := c.verifyChunk(); err != nil {
+			return err
+		}
+		if line, err := c.readLine(); err != nil || line != "" {
+			return errIncompleteBody
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return errIncompleteBody
+	}
+	size, signature, _ := strings.Cut(line, ";")
+	c.remaining, err = strconv.ParseInt(size, 16, 64)
+	if err != nil || c.remaining < 0 {
+		return errIncompleteBody
+	}
+	c.chunkSig = strings.TrimPrefix(signature, "chunk-signature=")
+	c.chunkHash.Reset()
+	if c.remaining != 0 {
+		return nil
+	}
+
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	if err := c.readTrailer(); err != nil {
+		return err
+	}
+	if c.decodedLength >= 0 && c.read != c.decodedLength {
+		return errIncompleteBody
+	}
+	return io.EOF
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if c.signer == nil {
+		return nil
+	}
+	return c.signer.verify("AWS4-HMAC-SHA256-PAYLOAD", c.chunkSig, emptySHA256, hex.EncodeToString(c.chunkHash.Sum(nil)))
+}
+
+// readTrailer reads the trailing headers after the final chunk, up to the
+// empty line ending the body, and verifies the trailing checksum.
+func (c *chunkedReader) readTrailer() error {
+	trailers := map[string]string{}
+	for {
+		line, err := c.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			// Some clients omit the empty line ending the body.
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return errIncompleteBody
+		}
+		trailers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	if c.trailer == "" {
+		return nil
+	}
+
+	value, ok := trailers[c.trailer]
+	if !ok {
+		return errIncompleteBody
+	}
+	if c.signer != nil {
+		canonical := sha256.Sum256([]byte(c.trailer + ":" + value + "\n"))
+		if err := c.signer.verify("AWS4-HMAC-SHA256-TRAILER", trailers["x-amz-trailer-signature"], hex.EncodeToString(canonical[:])); err != nil {
+			return err
+		}
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(expected) != c.sums[0].Hash.Size() {
+		err := errInvalidChecksum
+		err.Message = "Value for " + c.trailer + " trailing header is invalid."
+		return err
+	}
+	c.sums[0].Expected = expected
+	return c.sums.verify()
+}
+
+// readLine returns the next CRLF terminated line of the body, or io.EOF if
+// the body has ended.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF && len(line) == 0 {
+		return "", io.EOF
+	}
+	if err != nil {
+		return "", errIncompleteBody
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+}
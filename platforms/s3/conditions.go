@@ -0,0 +1,143 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conditionHeaders names the headers a set of preconditions is read from.
+type conditionHeaders struct {
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   string
+	IfUnmodifiedSince string
+}
+
+// requestConditions are the preconditions of a GET or HEAD request.
+var requestConditions = conditionHeaders{
+	IfMatch:           "If-Match",
+	IfNoneMatch:       "If-None-Match",
+	IfModifiedSince:   "If-Modified-Since",
+	IfUnmodifiedSince: "If-Unmodified-Since",
+}
+
+// copySourceConditions are the preconditions on the source object of a copy.
+var copySourceConditions = conditionHeaders{
+	IfMatch:           "X-Amz-Copy-Source-If-Match",
+	IfNoneMatch:       "X-Amz-Copy-Source-If-None-Match",
+	IfModifiedSince:   "X-Amz-Copy-Source-If-Modified-Since",
+	IfUnmodifiedSince: "X-Amz-Copy-Source-If-Unmodified-Since",
+}
+
+type conditionResult int
+
+const (
+	conditionsMet conditionResult = iota
+	conditionNotModified
+	conditionFailed
+)
+
+// evaluate checks the preconditions in header against an object with the
+// given etag and modification time.  As with S3, a matching If-Match
+// overrides If-Unmodified-Since and a failing If-None-Match overrides
+// If-Modified-Since.
+func (c conditionHeaders) evaluate(header http.Header, etag string, modTime time.Time) conditionResult {
+	modTime = modTime.Truncate(time.Second)
+
+	if v := header.Get(c.IfMatch); v != "" {
+		if !matchETag(v, etag) {
+			return conditionFailed
+		}
+	} else if t, err := http.ParseTime(header.Get(c.IfUnmodifiedSince)); err == nil {
+		if modTime.After(t) {
+			return conditionFailed
+		}
+	}
+
+	if v := header.Get(c.IfNoneMatch); v != "" {
+		if matchETag(v, etag) {
+			return conditionNotModified
+		}
+	} else if t, err := http.ParseTime(header.Get(c.IfModifiedSince)); err == nil {
+		if !modTime.After(t) {
+			return conditionNotModified
+		}
+	}
+
+	return conditionsMet
+}
+
+// matchETag reports if etag is one of the comma separated entity tags in
+// list, or list is "*".
+func matchETag(list, etag string) bool {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		v = strings.TrimPrefix(v, "W/")
+		if strings.Trim(v, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange is a satisfiable range of an object's contents.
+type byteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (b byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", b.Start, b.Start+b.Length-1, size)
+}
+
+// parseRange parses a Range header for an object of the given size.  Like
+// S3, only a single range is supported and headers which can not be parsed
+// are ignored, in both cases returning nil so the whole object is sent.
+// errInvalidRange is returned if the range can not be satisfied.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errInvalidRange
+		}
+		n = min(n, size)
+		return &byteRange{Start: size - n, Length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, errInvalidRange
+	}
+
+	return &byteRange{Start: start, Length: end - start + 1}, nil
+}
@@ -0,0 +1,193 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := map[string]struct {
+		header  string
+		want    *byteRange
+		wantErr error
+	}{
+		"none":            {header: ""},
+		"first bytes":     {header: "bytes=0-4", want: &byteRange{Start: 0, Length: 5}},
+		"middle":          {header: "bytes=2-3", want: &byteRange{Start: 2, Length: 2}},
+		"open ended":      {header: "bytes=6-", want: &byteRange{Start: 6, Length: 5}},
+		"past end":        {header: "bytes=6-100", want: &byteRange{Start: 6, Length: 5}},
+		"suffix":          {header: "bytes=-5", want: &byteRange{Start: 6, Length: 5}},
+		"suffix too long": {header: "bytes=-100", want: &byteRange{Start: 0, Length: 11}},
+		"multiple":        {header: "bytes=0-1,4-5"},
+		"other unit":      {header: "items=0-1"},
+		"reversed":        {header: "bytes=5-1"},
+		"malformed":       {header: "bytes=a-b"},
+		"unsatisfiable":   {header: "bytes=11-", wantErr: errInvalidRange},
+		"empty suffix":    {header: "bytes=-0", wantErr: errInvalidRange},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseRange(tc.header, 11)
+			if err != tc.wantErr {
+				t.Fatalf("expected err to be %v got %v", tc.wantErr, err)
+			}
+			if (got == nil) != (tc.want == nil) || got != nil && *got != *tc.want {
+				t.Errorf("expected range to be %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestConditionsEvaluate(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	at := modTime.Format(http.TimeFormat)
+	after := modTime.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := map[string]struct {
+		conditions conditionHeaders
+		headers    map[string]string
+		want       conditionResult
+	}{
+		"none":                      {conditions: requestConditions, want: conditionsMet},
+		"if match":                  {conditions: requestConditions, headers: map[string]string{"If-Match": `"etag"`}, want: conditionsMet},
+		"if match list":             {conditions: requestConditions, headers: map[string]string{"If-Match": `"other", "etag"`}, want: conditionsMet},
+		"if match any":              {conditions: requestConditions, headers: map[string]string{"If-Match": "*"}, want: conditionsMet},
+		"if match fails":            {conditions: requestConditions, headers: map[string]string{"If-Match": `"other"`}, want: conditionFailed},
+		"if none match":             {conditions: requestConditions, headers: map[string]string{"If-None-Match": `"etag"`}, want: conditionNotModified},
+		"if none match weak":        {conditions: requestConditions, headers: map[string]string{"If-None-Match": `W/"etag"`}, want: conditionNotModified},
+		"if none match other":       {conditions: requestConditions, headers: map[string]string{"If-None-Match": `"other"`}, want: conditionsMet},
+		"if modified since before":  {conditions: requestConditions, headers: map[string]string{"If-Modified-Since": before}, want: conditionsMet},
+		"if modified since at":      {conditions: requestConditions, headers: map[string]string{"If-Modified-Since": at}, want: conditionNotModified},
+		"if unmodified since after": {conditions: requestConditions, headers: map[string]string{"If-Unmodified-Since": after}, want: conditionsMet},
+		"if unmodified since at":    {conditions: requestConditions, headers: map[string]string{"If-Unmodified-Since": at}, want: conditionsMet},
+		"if unmodified since fails": {conditions: requestConditions, headers: map[string]string{"If-Unmodified-Since": before}, want: conditionFailed},
+		"if match overrides unmodified since": {
+			conditions: requestConditions,
+			headers:    map[string]string{"If-Match": `"etag"`, "If-Unmodified-Since": before},
+			want:       conditionsMet,
+		},
+		"if none match overrides modified since": {
+			conditions: requestConditions,
+			headers:    map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after},
+			want:       conditionsMet,
+		},
+		"copy source if match fails": {
+			conditions: copySourceConditions,
+			headers:    map[string]string{"X-Amz-Copy-Source-If-Match": `"other"`},
+			want:       conditionFailed,
+		},
+		"copy source ignores request headers": {
+			conditions: copySourceConditions,
+			headers:    map[string]string{"If-Match": `"other"`},
+			want:       conditionsMet,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tc.headers {
+				header.Set(k, v)
+			}
+			if got := tc.conditions.evaluate(header, "etag", modTime); got != tc.want {
+				t.Errorf("expected result to be %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRangeAndConditionalRequests(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket/key", "hello world")
+	etag := `"5eb63bbbe01eeed093cb22bb8f5acdc3"`
+
+	tests := map[string]struct {
+		method      string
+		headers     []string
+		wantCode    int
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		"range": {
+			method:   http.MethodGet,
+			headers:  []string{"Range", "bytes=0-4"},
+			wantCode: http.StatusPartialContent,
+			wantBody: "hello",
+			wantHeaders: map[string]string{
+				"Content-Range":  "bytes 0-4/11",
+				"Content-Length": "5",
+				"Accept-Ranges":  "bytes",
+			},
+		},
+		"suffix range": {
+			method:      http.MethodGet,
+			headers:     []string{"Range", "bytes=-5"},
+			wantCode:    http.StatusPartialContent,
+			wantBody:    "world",
+			wantHeaders: map[string]string{"Content-Range": "bytes 6-10/11"},
+		},
+		"head range": {
+			method:      http.MethodHead,
+			headers:     []string{"Range", "bytes=6-"},
+			wantCode:    http.StatusPartialContent,
+			wantHeaders: map[string]string{"Content-Range": "bytes 6-10/11", "Content-Length": "5"},
+		},
+		"unsatisfiable range": {
+			method:      http.MethodGet,
+			headers:     []string{"Range", "bytes=20-"},
+			wantCode:    http.StatusRequestedRangeNotSatisfiable,
+			wantBody:    "<Code>InvalidRange</Code>",
+			wantHeaders: map[string]string{"Content-Range": "bytes */11"},
+		},
+		"multiple ranges": {
+			method:   http.MethodGet,
+			headers:  []string{"Range", "bytes=0-1,3-4"},
+			wantCode: http.StatusOK,
+			wantBody: "hello world",
+		},
+		"not modified": {
+			method:      http.MethodGet,
+			headers:     []string{"If-None-Match", etag},
+			wantCode:    http.StatusNotModified,
+			wantHeaders: map[string]string{"ETag": etag},
+		},
+		"precondition failed": {
+			method:   http.MethodGet,
+			headers:  []string{"If-Match", `"other"`},
+			wantCode: http.StatusPreconditionFailed,
+			wantBody: "<Code>PreconditionFailed</Code>",
+		},
+		"head precondition failed": {
+			method:   http.MethodHead,
+			headers:  []string{"If-Match", `"other"`},
+			wantCode: http.StatusPreconditionFailed,
+		},
+		"if match with range": {
+			method:   http.MethodGet,
+			headers:  []string{"If-Match", etag, "Range", "bytes=6-10"},
+			wantCode: http.StatusPartialContent,
+			wantBody: "world",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, tc.method, "/bucket/key", "", tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+			if tc.wantBody != "" && !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+			for header, want := range tc.wantHeaders {
+				if got := res.Header().Get(header); got != want {
+					t.Errorf("expected %v to be %q got %q", header, want, got)
+				}
+			}
+		})
+	}
+}
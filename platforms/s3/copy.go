@@ -0,0 +1,300 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type copyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// parseCopySource parses the x-amz-copy-source header, which is the URL
+// encoded bucket and key of the source object, optionally starting with a
+// slash and followed by a versionId query parameter.
+func parseCopySource(header string) (bucket, key, versionID string, ok bool) {
+	header, rawQuery, _ := strings.Cut(header, "?")
+	source, err := url.PathUnescape(strings.TrimPrefix(header, "/"))
+	if err != nil {
+		return "", "", "", false
+	}
+	bucket, key, _ = strings.Cut(source, "/")
+	if !validBucketName(bucket) || validKey(key) != nil {
+		return "", "", "", false
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", "", "", false
+	}
+	return bucket, key, query.Get("versionId"), true
+}
+
+// readCopySource returns the object named by the x-amz-copy-source header,
+// writing the response and returning false when it may not be read, does
+// not exist or its preconditions fail.
+func (h Handler) readCopySource(w http.ResponseWriter, r *http.Request) (objectFile, bool) {
+	src := objectFile{}
+
+	bucket, key, versionID, ok := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if !ok {
+		writeError(w, r, errInvalidCopySource)
+		return src, false
+	}
+
+	// The source is read, so the principal must be allowed to get it as
+	// well as to put the copy.
+	action := "s3:GetObject"
+	if versionID != "" {
+		action = "s3:GetObjectVersion"
+	}
+	if err := h.authorize(r, action, bucket, key); err != nil {
+		writeStoreError(w, r, err)
+		return src, false
+	}
+
+	if !h.bucketExists(bucket) {
+		writeError(w, r, errNoSuchBucket)
+		return src, false
+	}
+
+	obj, err := h.findObject(bucket, key, versionID)
+	if err != nil {
+		writeVersionError(w, r, err)
+		return src, false
+	}
+
+	// Unlike GET, a copy whose source was not modified fails.
+	if copySourceConditions.evaluate(r.Header, obj.Meta.ETag, obj.Info.ModTime()) != conditionsMet {
+		writeError(w, r, errPreconditionFailed)
+		return src, false
+	}
+
+	if obj.Meta.VersionID != "" {
+		w.Header().Set("X-Amz-Copy-Source-Version-Id", obj.Meta.VersionID)
+	}
+	return obj, true
+}
+
+// openCopySource returns the contents of the source object from offset,
+// decrypted with dataKey unless it is nil.  Folder objects are always
+// empty.
+func openCopySource(src objectFile, dataKey []byte, offset int64) (io.ReadCloser, error) {
+	if src.Info.IsDir() {
+		return emptyObject{strings.NewReader("")}, nil
+	}
+	return openData(src.Name, dataKey, offset)
+}
+
+type emptyObject struct {
+	*strings.Reader
+}
+
+func (emptyObject) Close() error {
+	return nil
+}
+
+func (h Handler) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	io.Copy(io.Discard, r.Body)
+
+	src, ok := h.readCopySource(w, r)
+	if !ok {
+		return
+	}
+	srcKey, err := h.dataKey(src.Meta, r.Header, sseCopySourceHeaders)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	// The copy is only encrypted when requested, whatever the encryption
+	// of the source.
+	encryption, dataKey, err := h.newEncryption(r.Header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	meta := objectMeta{Headers: src.Meta.Headers, UserMetadata: src.Meta.UserMetadata}
+	switch r.Header.Get("X-Amz-Metadata-Directive") {
+	case "", "COPY":
+		if src.Name == h.objectPath(bucket, key) && encryption == nil {
+			writeError(w, r, errInvalidCopyRequest)
+			return
+		}
+	case "REPLACE":
+		meta, err = newObjectMeta(r.Header)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	default:
+		writeError(w, r, errInvalidMetadataDirective)
+		return
+	}
+	meta.Encryption, meta.dataKey = encryption, dataKey
+
+	switch r.Header.Get("X-Amz-Tagging-Directive") {
+	case "", "COPY":
+		meta.Tags = src.Meta.Tags
+	case "REPLACE":
+		tags, err := parseTaggingHeader(r.Header.Get("X-Amz-Tagging"))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		meta.Tags = tags
+	default:
+		writeError(w, r, errInvalidTaggingDirective)
+		return
+	}
+
+	if isFolderKey(key) {
+		if objectSize(src.Info) != 0 {
+			writeError(w, r, errFolderContent)
+			return
+		}
+		if err := h.storeFolder(bucket, key, meta); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		meta.ETag = emptyMD5
+	} else {
+		f, err := openCopySource(src, srcKey, 0)
+		if err != nil {
+			writeError(w, r, errInternalError)
+			return
+		}
+		defer f.Close()
+
+		// The checksum is recomputed, as a COMPOSITE checksum of the source
+		// does not apply to the copy.
+		algorithm := src.Meta.ChecksumAlgorithm
+		if v := r.Header.Get("X-Amz-Checksum-Algorithm"); v != "" {
+			alg, ok := findChecksumAlgorithm(v)
+			if !ok {
+				writeError(w, r, errUnsupportedChecksumAlgorithm)
+				return
+			}
+			algorithm = alg.Name
+		}
+		body, err := newChecksumReader(f, http.Header{}, algorithm)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+
+		meta, err = h.storeObject(bucket, key, body, meta)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+
+	info, err := os.Stat(h.objectPath(bucket, key))
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	if meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", meta.VersionID)
+	}
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+	writeXML(w, http.StatusOK, copyObjectResult{
+		Xmlns:        xmlns,
+		LastModified: formatISO8601(info.ModTime()),
+		ETag:         quoteETag(meta.ETag),
+	})
+	h.notify(w, r, "ObjectCreated:Copy", bucket, key, meta.VersionID)
+}
+
+func (h Handler) uploadPartCopy(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	io.Copy(io.Discard, r.Body)
+	query := r.URL.Query()
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeError(w, r, errInvalidPartNumber)
+		return
+	}
+
+	u, err := h.readUpload(bucket, key, query.Get("uploadId"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	dataKey, err := h.uploadDataKey(u, r.Header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	src, ok := h.readCopySource(w, r)
+	if !ok {
+		return
+	}
+	srcKey, err := h.dataKey(src.Meta, r.Header, sseCopySourceHeaders)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	offset, length := int64(0), int64(-1)
+	if header := r.Header.Get("X-Amz-Copy-Source-Range"); header != "" {
+		size := src.Meta.dataSize(objectSize(src.Info))
+		rng, err := parseRange(header, size)
+		if err != nil || rng == nil {
+			writeError(w, r, errInvalidRange)
+			return
+		}
+		offset, length = rng.Start, rng.Length
+	}
+
+	f, err := openCopySource(src, srcKey, offset)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	defer f.Close()
+
+	var body io.Reader = f
+	if length >= 0 {
+		body = io.LimitReader(f, length)
+	}
+
+	sum, err := newChecksumReader(body, http.Header{}, u.Meta.ChecksumAlgorithm)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	p, err := h.storePart(bucket, u.UploadID, partNumber, sum, dataKey)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeEncryptionHeaders(w.Header(), u.Meta.Encryption)
+	writeXML(w, http.StatusOK, copyPartResult{
+		Xmlns:        xmlns,
+		LastModified: formatISO8601(p.LastModified),
+		ETag:         quoteETag(p.ETag),
+	})
+}
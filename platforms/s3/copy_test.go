@@ -0,0 +1,163 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCopyObject(t *testing.T) {
+	etag := `"421b47ffd946ca083b65cd668c6b17e6"`
+
+	tests := map[string]struct {
+		target      string
+		headers     []string
+		wantCode    int
+		wantHeaders map[string]string
+	}{
+		"copy metadata": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/clip%20one.mp4"},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":     "video/mp4",
+				"X-Amz-Meta-Stage": "incoming",
+			},
+		},
+		"replace metadata": {
+			target: "/processed/clip.mp4",
+			headers: []string{
+				"X-Amz-Copy-Source", "incoming/clip%20one.mp4",
+				"X-Amz-Metadata-Directive", "REPLACE",
+				"Content-Type", "application/octet-stream",
+				"X-Amz-Meta-Stage", "processed",
+			},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Content-Type":     "application/octet-stream",
+				"X-Amz-Meta-Stage": "processed",
+			},
+		},
+		"replace in place": {
+			target: "/incoming/clip%20one.mp4",
+			headers: []string{
+				"X-Amz-Copy-Source", "/incoming/clip%20one.mp4",
+				"X-Amz-Metadata-Directive", "REPLACE",
+				"X-Amz-Meta-Stage", "done",
+			},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{"X-Amz-Meta-Stage": "done"},
+		},
+		"copy in place": {
+			target:   "/incoming/clip%20one.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/clip%20one.mp4"},
+			wantCode: http.StatusBadRequest,
+		},
+		"invalid directive": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/clip%20one.mp4", "X-Amz-Metadata-Directive", "MOVE"},
+			wantCode: http.StatusBadRequest,
+		},
+		"missing source": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/missing.mp4"},
+			wantCode: http.StatusNotFound,
+		},
+		"missing source bucket": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/missing/clip%20one.mp4"},
+			wantCode: http.StatusNotFound,
+		},
+		"source if match": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/clip%20one.mp4", "X-Amz-Copy-Source-If-Match", etag},
+			wantCode: http.StatusOK,
+		},
+		"source if none match": {
+			target:   "/processed/clip.mp4",
+			headers:  []string{"X-Amz-Copy-Source", "/incoming/clip%20one.mp4", "X-Amz-Copy-Source-If-None-Match", etag},
+			wantCode: http.StatusPreconditionFailed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "incoming", "processed")
+			doRequest(h, http.MethodPut, "/incoming/clip%20one.mp4", "video",
+				"Content-Type", "video/mp4",
+				"X-Amz-Meta-Stage", "incoming",
+			)
+
+			res := doRequest(h, http.MethodPut, tc.target, "", tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+
+			result := copyObjectResult{}
+			if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			if result.ETag != etag {
+				t.Errorf("expected etag to be %v got %v", etag, result.ETag)
+			}
+
+			res = doRequest(h, http.MethodGet, tc.target, "")
+			if res.Body.String() != "video" {
+				t.Errorf("expected body to be %q got %q", "video", res.Body.String())
+			}
+			for header, want := range tc.wantHeaders {
+				if got := res.Header().Get(header); got != want {
+					t.Errorf("expected %v to be %q got %q", header, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadPartCopy(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	source := strings.Repeat("a", minPartSize) + "tail"
+	doRequest(h, http.MethodPut, "/bucket/source", source)
+
+	target := "/bucket/target"
+	uploadID := createUpload(t, h, target)
+
+	var etags []string
+	for i, sourceRange := range []string{"bytes=0-5242879", "bytes=5242880-5242883"} {
+		res := doRequest(h, http.MethodPut, fmt.Sprintf("%s?partNumber=%d&uploadId=%s", target, i+1, uploadID), "",
+			"X-Amz-Copy-Source", "/bucket/source",
+			"X-Amz-Copy-Source-Range", sourceRange,
+		)
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected copy part status to be %v got %v", http.StatusOK, res.Code)
+		}
+		result := copyPartResult{}
+		if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+		etags = append(etags, result.ETag)
+	}
+
+	res := doRequest(h, http.MethodPut, target+"?partNumber=3&uploadId="+uploadID, "",
+		"X-Amz-Copy-Source", "/bucket/source",
+		"X-Amz-Copy-Source-Range", "bytes=6000000-6000001",
+	)
+	if res.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected out of range status to be %v got %v", http.StatusRequestedRangeNotSatisfiable, res.Code)
+	}
+
+	res = doRequest(h, http.MethodPost, target+"?uploadId="+uploadID, completeBody(etags...))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected complete status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	res = doRequest(h, http.MethodGet, target, "")
+	if res.Body.String() != source {
+		t.Errorf("expected copied object to match the source")
+	}
+}
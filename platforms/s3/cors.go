@@ -0,0 +1,237 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxCORSRules is the maximum number of rules in a bucket's CORS
+// configuration.
+const maxCORSRules = 100
+
+var corsMethods = []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodHead}
+
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration" json:"-"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty" json:"-"`
+	Rules   []corsRule `xml:"CORSRule" json:"rules"`
+}
+
+type corsRule struct {
+	ID             string   `xml:"ID,omitempty" json:"id,omitempty"`
+	AllowedHeaders []string `xml:"AllowedHeader" json:"allowedHeaders,omitempty"`
+	AllowedMethods []string `xml:"AllowedMethod" json:"allowedMethods"`
+	AllowedOrigins []string `xml:"AllowedOrigin" json:"allowedOrigins"`
+	ExposeHeaders  []string `xml:"ExposeHeader" json:"exposeHeaders,omitempty"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds" json:"maxAgeSeconds,omitempty"`
+}
+
+func (h Handler) putBucketCors(w http.ResponseWriter, r *http.Request, bucket string) {
+	config := corsConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	if err := validCORSConfiguration(config); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if err := h.writeJSON(h.systemPath(bucket, "cors.json"), config); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) getBucketCors(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := h.readCORSConfiguration(bucket)
+	if err != nil {
+		if isNotExist(err) {
+			writeError(w, r, errNoSuchCORSConfiguration)
+			return
+		}
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	config.Xmlns = xmlns
+	writeXML(w, http.StatusOK, config)
+}
+
+func (h Handler) deleteBucketCors(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := os.Remove(h.systemPath(bucket, "cors.json")); err != nil && !isNotExist(err) {
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) readCORSConfiguration(bucket string) (corsConfiguration, error) {
+	config := corsConfiguration{}
+	err := readJSON(h.systemPath(bucket, "cors.json"), &config)
+	return config, err
+}
+
+// validCORSConfiguration returns the apiError S3 responds with when config
+// is rejected, or nil if it is valid.
+func validCORSConfiguration(config corsConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > maxCORSRules {
+		return errMalformedXML
+	}
+
+	for _, rule := range config.Rules {
+		if len(rule.AllowedMethods) == 0 || len(rule.AllowedOrigins) == 0 || len(rule.ID) > 255 {
+			return errMalformedXML
+		}
+		for _, m := range rule.AllowedMethods {
+			if !slices.Contains(corsMethods, m) {
+				err := errInvalidCORSMethod
+				err.Message += m
+				return err
+			}
+		}
+		for _, o := range rule.AllowedOrigins {
+			if strings.Count(o, "*") > 1 {
+				err := errInvalidCORSWildcard
+				err.Message = "AllowedOrigin \"" + o + "\" can not have more than one wildcard."
+				return err
+			}
+		}
+		for _, hdr := range rule.AllowedHeaders {
+			if strings.Count(hdr, "*") > 1 {
+				err := errInvalidCORSWildcard
+				err.Message = "AllowedHeader \"" + hdr + "\" can not have more than one wildcard."
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// preflight responds to a CORS preflight request for bucket with the first
+// rule allowing the origin, method and headers requested.
+func (h Handler) preflight(w http.ResponseWriter, r *http.Request, bucket string) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		writeError(w, r, errCORSMissingOrigin)
+		return
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(corsMethods, method) {
+		err := errInvalidCORSRequestMethod
+		err.Message += method
+		writeError(w, r, err)
+		return
+	}
+
+	if !h.bucketExists(bucket) {
+		writeError(w, r, errCORSBucketNotFound)
+		return
+	}
+
+	config, err := h.readCORSConfiguration(bucket)
+	if err != nil {
+		if isNotExist(err) {
+			writeError(w, r, errCORSNotEnabled)
+			return
+		}
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	var headers []string
+	for _, hdr := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if hdr = strings.TrimSpace(hdr); hdr != "" {
+			headers = append(headers, strings.ToLower(hdr))
+		}
+	}
+
+	rule, ok := config.match(origin, method, headers)
+	if !ok {
+		writeError(w, r, errCORSNotAllowed)
+		return
+	}
+
+	writeCORSHeaders(w, rule, origin)
+	if len(headers) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setCORSHeaders sets the CORS headers for a request with an Origin header
+// when a rule of the bucket's configuration allows it.
+func (h Handler) setCORSHeaders(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := h.readCORSConfiguration(bucket)
+	if err != nil {
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if rule, ok := config.match(origin, r.Method, nil); ok {
+		writeCORSHeaders(w, rule, origin)
+	}
+}
+
+// match returns the first rule which allows requests from origin using
+// method and including headers.
+func (c corsConfiguration) match(origin, method string, headers []string) (corsRule, bool) {
+	for _, rule := range c.Rules {
+		if !slices.Contains(rule.AllowedMethods, method) {
+			continue
+		}
+		if !slices.ContainsFunc(rule.AllowedOrigins, func(o string) bool { return matchWildcard(o, origin) }) {
+			continue
+		}
+		denied := func(hdr string) bool {
+			return !slices.ContainsFunc(rule.AllowedHeaders, func(a string) bool {
+				return matchWildcard(strings.ToLower(a), hdr)
+			})
+		}
+		if slices.ContainsFunc(headers, denied) {
+			continue
+		}
+		return rule, true
+	}
+	return corsRule{}, false
+}
+
+func writeCORSHeaders(w http.ResponseWriter, rule corsRule, origin string) {
+	header := w.Header()
+	header.Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+
+	// Rules allowing any origin do not allow credentials.
+	if slices.Contains(rule.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) != 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+}
+
+// matchWildcard reports whether s matches pattern, which may contain a
+// single * matching any number of characters.
+func matchWildcard(pattern, s string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == s
+	}
+	return len(s) >= len(prefix)+len(suffix) && strings.HasPrefix(s, prefix) && strings.HasSuffix(s, suffix)
+}
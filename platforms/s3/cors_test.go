@@ -0,0 +1,201 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testCORS = `<CORSConfiguration>
+  <CORSRule>
+    <AllowedOrigin>https://*.example.com</AllowedOrigin>
+    <AllowedMethod>PUT</AllowedMethod>
+    <AllowedMethod>POST</AllowedMethod>
+    <AllowedHeader>Content-*</AllowedHeader>
+    <AllowedHeader>x-amz-*</AllowedHeader>
+    <ExposeHeader>ETag</ExposeHeader>
+    <MaxAgeSeconds>3000</MaxAgeSeconds>
+  </CORSRule>
+  <CORSRule>
+    <AllowedOrigin>*</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+  </CORSRule>
+</CORSConfiguration>`
+
+func TestBucketCorsConfiguration(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodGet, "/bucket?cors", "")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), "NoSuchCORSConfiguration") {
+		t.Errorf("expected missing configuration to be %v got %v %s", http.StatusNotFound, res.Code, res.Body.String())
+	}
+
+	tests := map[string]struct {
+		body     string
+		wantCode int
+	}{
+		"valid":              {body: testCORS, wantCode: http.StatusOK},
+		"malformed":          {body: `<CORSConfiguration>`, wantCode: http.StatusBadRequest},
+		"no rules":           {body: `<CORSConfiguration></CORSConfiguration>`, wantCode: http.StatusBadRequest},
+		"no origin":          {body: `<CORSConfiguration><CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`, wantCode: http.StatusBadRequest},
+		"unsupported method": {body: `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule></CORSConfiguration>`, wantCode: http.StatusBadRequest},
+		"two wildcards":      {body: `<CORSConfiguration><CORSRule><AllowedOrigin>https://*.*.com</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`, wantCode: http.StatusBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, http.MethodPut, "/bucket?cors", tc.body)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+		})
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket?cors", "")
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "<AllowedOrigin>https://*.example.com</AllowedOrigin>") {
+		t.Errorf("expected configuration got %v %s", res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket?cors", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected delete status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+	res = doRequest(h, http.MethodGet, "/bucket?cors", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected deleted configuration status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected the bucket to remain got %v", res.Code)
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	h := newTestHandler(t, "bucket", "plain")
+	doRequest(h, http.MethodPut, "/bucket?cors", testCORS)
+	doRequest(h, http.MethodPut, "/bucket/key", "data")
+
+	tests := map[string]struct {
+		method      string
+		target      string
+		headers     []string
+		wantCode    int
+		wantHeaders map[string]string
+	}{
+		"preflight": {
+			method: http.MethodOptions,
+			target: "/bucket/key",
+			headers: []string{
+				"Origin", "https://app.example.com",
+				"Access-Control-Request-Method", "PUT",
+				"Access-Control-Request-Headers", "Content-Type, X-Amz-Date",
+			},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "PUT, POST",
+				"Access-Control-Allow-Headers":     "content-type, x-amz-date",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "ETag",
+				"Access-Control-Max-Age":           "3000",
+			},
+		},
+		"preflight any origin": {
+			method:   http.MethodOptions,
+			target:   "/bucket",
+			headers:  []string{"Origin", "https://other.test", "Access-Control-Request-Method", "GET"},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		"preflight header not allowed": {
+			method: http.MethodOptions,
+			target: "/bucket/key",
+			headers: []string{
+				"Origin", "https://app.example.com",
+				"Access-Control-Request-Method", "PUT",
+				"Access-Control-Request-Headers", "Authorization",
+			},
+			wantCode:    http.StatusForbidden,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		"preflight method not allowed": {
+			method:   http.MethodOptions,
+			target:   "/bucket/key",
+			headers:  []string{"Origin", "https://other.test", "Access-Control-Request-Method", "DELETE"},
+			wantCode: http.StatusForbidden,
+		},
+		"preflight missing origin": {
+			method:   http.MethodOptions,
+			target:   "/bucket/key",
+			headers:  []string{"Access-Control-Request-Method", "GET"},
+			wantCode: http.StatusBadRequest,
+		},
+		"preflight invalid method": {
+			method:   http.MethodOptions,
+			target:   "/bucket/key",
+			headers:  []string{"Origin", "https://other.test", "Access-Control-Request-Method", "PATCH"},
+			wantCode: http.StatusBadRequest,
+		},
+		"preflight not enabled": {
+			method:   http.MethodOptions,
+			target:   "/plain/key",
+			headers:  []string{"Origin", "https://other.test", "Access-Control-Request-Method", "GET"},
+			wantCode: http.StatusForbidden,
+		},
+		"preflight missing bucket": {
+			method:   http.MethodOptions,
+			target:   "/missing/key",
+			headers:  []string{"Origin", "https://other.test", "Access-Control-Request-Method", "GET"},
+			wantCode: http.StatusForbidden,
+		},
+		"actual request": {
+			method:   http.MethodGet,
+			target:   "/bucket/key",
+			headers:  []string{"Origin", "https://other.test"},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET",
+			},
+		},
+		"actual request error": {
+			method:      http.MethodGet,
+			target:      "/bucket/missing",
+			headers:     []string{"Origin", "https://other.test"},
+			wantCode:    http.StatusNotFound,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		"actual request not allowed": {
+			method:      http.MethodDelete,
+			target:      "/bucket/other",
+			headers:     []string{"Origin", "https://other.test"},
+			wantCode:    http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		"actual request not enabled": {
+			method:      http.MethodGet,
+			target:      "/plain",
+			headers:     []string{"Origin", "https://other.test"},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, tc.method, tc.target, "", tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+			for header, want := range tc.wantHeaders {
+				if got := res.Header().Get(header); got != want {
+					t.Errorf("expected %v to be %q got %q", header, want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hurricanerix/http-helper/config"
+)
+
+// credentials maps access key IDs to their secret access keys.
+type credentials map[string]string
+
+// AccessKey is an access key ID and its secret access key.
+type AccessKey struct {
+	ID     string
+	Secret string
+}
+
+// AccessKeys returns the access keys configured in HH_S3_CREDENTIALS and the
+// file named by HH_S3_CREDENTIALS_FILE, in the order they are configured.
+// Both use the form ACCESS_KEY_ID:SECRET_ACCESS_KEY, separated by commas in
+// the env variable and one per line in the file.  Lines in the file starting
+// with # are ignored.
+func AccessKeys() []AccessKey {
+	keys := []AccessKey{}
+	add := func(pair string) {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && id != "" && secret != "" {
+			keys = append(keys, AccessKey{ID: id, Secret: secret})
+		}
+	}
+
+	for _, pair := range config.StringSliceEnv("HH_S3_CREDENTIALS", "") {
+		add(pair)
+	}
+
+	name := config.StringEnv("HH_S3_CREDENTIALS_FILE", "")
+	if name == "" {
+		return keys
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to read s3 credentials: %v\n", err)
+		return keys
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		add(line)
+	}
+
+	return keys
+}
+
+// loadCredentials returns the access keys returned by AccessKeys.
+func loadCredentials() credentials {
+	creds := credentials{}
+	for _, key := range AccessKeys() {
+		creds[key.ID] = key.Secret
+	}
+	return creds
+}
@@ -0,0 +1,28 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAccessKeys(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "credentials")
+	content := "# local keys\nAKIAFILE:file-secret\n\ninvalid\nAKIAFILE2:file-secret2\n"
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	t.Setenv("HH_S3_CREDENTIALS", "AKIAENV:env-secret, AKIAEMPTY:")
+	t.Setenv("HH_S3_CREDENTIALS_FILE", name)
+
+	want := []AccessKey{
+		{ID: "AKIAENV", Secret: "env-secret"},
+		{ID: "AKIAFILE", Secret: "file-secret"},
+		{ID: "AKIAFILE2", Secret: "file-secret2"},
+	}
+	if diff := cmp.Diff(want, AccessKeys()); diff != "" {
+		t.Errorf("unexpected access keys (-want +got):\n%s", diff)
+	}
+}
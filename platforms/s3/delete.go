@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// maxDeleteObjects is the maximum number of keys which can be deleted in a
+// single DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// maxDeleteSize limits the size of the Delete document read.  1000 keys of
+// the maximum length fit comfortably.
+const maxDeleteSize = 2 * 1024 * 1024
+
+type deleteRequest struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []objectIdentifier `xml:"Object"`
+}
+
+type objectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name      `xml:"DeleteResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Deleted []deletedInfo `xml:"Deleted"`
+	Errors  []deleteError `xml:"Error"`
+}
+
+type deletedInfo struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type deleteError struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+func (h Handler) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	sums, err := newChecksums(r.Header)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(sums) == 0 {
+		io.Copy(io.Discard, r.Body)
+		writeError(w, r, errMissingContentMD5)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDeleteSize+1))
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	if len(body) > maxDeleteSize {
+		io.Copy(io.Discard, r.Body)
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	sums.Write(body)
+	if err := sums.verify(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	req := deleteRequest{}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	result := deleteResult{Xmlns: xmlns}
+	for _, obj := range req.Objects {
+		action := "s3:DeleteObject"
+		if obj.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		err := h.authorize(r, action, bucket, obj.Key)
+		deleted := deletedObject{}
+		if err == nil {
+			deleted, err = h.deleteIdentifiedObject(bucket, obj)
+		}
+		if err != nil {
+			apiErr := apiError{}
+			if !errors.As(err, &apiErr) {
+				apiErr = errInternalError
+			}
+			result.Errors = append(result.Errors, deleteError{
+				Key:       obj.Key,
+				VersionID: obj.VersionID,
+				Code:      apiErr.Code,
+				Message:   apiErr.Message,
+			})
+			continue
+		}
+
+		h.notify(w, r, deleted.event(obj.VersionID != ""), bucket, obj.Key, deleted.VersionID)
+
+		// Quiet mode only reports the keys which could not be deleted.
+		if req.Quiet {
+			continue
+		}
+		info := deletedInfo{Key: obj.Key, VersionID: obj.VersionID}
+		if deleted.DeleteMarker {
+			info.DeleteMarker = true
+			info.DeleteMarkerVersionID = deleted.VersionID
+		}
+		result.Deleted = append(result.Deleted, info)
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// deleteIdentifiedObject deletes the object, or version of an object, named
+// by an entry of a DeleteObjects request.
+func (h Handler) deleteIdentifiedObject(bucket string, obj objectIdentifier) (deletedObject, error) {
+	if err := validKey(obj.Key); err != nil {
+		return deletedObject{}, err
+	}
+
+	if obj.VersionID != "" {
+		return h.deleteObjectVersion(bucket, obj.Key, obj.VersionID)
+	}
+	return h.deleteCurrentObject(bucket, obj.Key)
+}
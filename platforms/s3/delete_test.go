@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// deleteBody returns a Delete document for keys, each of which may include
+// a version ID after a space, and its Content-MD5.
+func deleteBody(quiet bool, keys ...string) (string, string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<Delete><Quiet>%v</Quiet>", quiet)
+	for _, k := range keys {
+		key, versionID, _ := strings.Cut(k, " ")
+		fmt.Fprintf(&b, "<Object><Key>%s</Key><VersionId>%s</VersionId></Object>", key, versionID)
+	}
+	b.WriteString("</Delete>")
+
+	sum := md5.Sum([]byte(b.String()))
+	return b.String(), base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// deleteObjectsResult returns the results of a DeleteObjects response as
+// "<element> <key> <code or delete marker>" lines.
+func deleteObjectsResult(t *testing.T, h Handler, body, md5 string) []string {
+	t.Helper()
+	res := doRequest(h, http.MethodPost, "/bucket?delete", body, "Content-MD5", md5)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected delete status to be %v got %v %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	result := deleteResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	lines := []string{}
+	for _, d := range result.Deleted {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("Deleted %s %s", d.Key, d.DeleteMarkerVersionID)))
+	}
+	for _, e := range result.Errors {
+		lines = append(lines, "Error "+e.Key+" "+e.Code)
+	}
+	return lines
+}
+
+func TestDeleteObjects(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	for _, k := range []string{"a", "b", "dir/c", "folder/"} {
+		doRequest(h, http.MethodPut, "/bucket/"+k, "")
+	}
+
+	body, sum := deleteBody(false, "a", "dir/c", "missing", "bad//key", "b 0123456789abcdef0123456789abcdef", "b bad")
+	got := deleteObjectsResult(t, h, body, sum)
+	want := []string{
+		"Deleted a",
+		"Deleted dir/c",
+		"Deleted missing",
+		"Deleted b",
+		"Error bad//key InvalidArgument",
+		"Error b InvalidArgument",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results: %s", diff)
+	}
+
+	_, keys, _ := listKeys(t, h, "/bucket?list-type=2")
+	if diff := cmp.Diff([]string{"b", "folder/"}, keys); diff != "" {
+		t.Errorf("unexpected keys after delete: %s", diff)
+	}
+
+	body, sum = deleteBody(true, "b", "folder/", "bad//key")
+	got = deleteObjectsResult(t, h, body, sum)
+	if diff := cmp.Diff([]string{"Error bad//key InvalidArgument"}, got); diff != "" {
+		t.Errorf("unexpected quiet results: %s", diff)
+	}
+
+	_, keys, _ = listKeys(t, h, "/bucket?list-type=2")
+	if diff := cmp.Diff([]string{}, keys); diff != "" {
+		t.Errorf("unexpected keys after quiet delete: %s", diff)
+	}
+}
+
+func TestDeleteObjectsVersioned(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	v1 := doRequest(h, http.MethodPut, "/bucket/key", "one").Header().Get("X-Amz-Version-Id")
+	doRequest(h, http.MethodPut, "/bucket/other", "one")
+
+	body, sum := deleteBody(false, "key", "other")
+	res := doRequest(h, http.MethodPost, "/bucket?delete", body, "Content-MD5", sum)
+	result := deleteResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if len(result.Deleted) != 2 || !result.Deleted[0].DeleteMarker || result.Deleted[0].DeleteMarkerVersionID == "" {
+		t.Fatalf("expected delete markers to be created got %+v", result.Deleted)
+	}
+	marker := result.Deleted[0].DeleteMarkerVersionID
+
+	body, sum = deleteBody(false, "key "+marker, "key "+v1)
+	got := deleteObjectsResult(t, h, body, sum)
+	want := []string{
+		"Deleted key " + marker,
+		"Deleted key",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected results: %s", diff)
+	}
+
+	_, versions := listVersions(t, h, "/bucket?versions&prefix=key")
+	if diff := cmp.Diff([]string{}, versions); diff != "" {
+		t.Errorf("expected all versions to be deleted: %s", diff)
+	}
+}
+
+func TestDeleteObjectsValidation(t *testing.T) {
+	body, sum := deleteBody(false, "a")
+	keys := make([]string, maxDeleteObjects+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	tooMany, tooManySum := deleteBody(false, keys...)
+	empty, emptySum := deleteBody(false)
+
+	tests := map[string]struct {
+		body     string
+		headers  []string
+		wantCode int
+		want     string
+	}{
+		"content md5":       {body: body, headers: []string{"Content-MD5", sum}, wantCode: http.StatusOK},
+		"checksum":          {body: body, headers: []string{"X-Amz-Checksum-Crc32", "ayWIyw=="}, wantCode: http.StatusOK},
+		"missing checksum":  {body: body, wantCode: http.StatusBadRequest, want: "InvalidRequest"},
+		"md5 mismatch":      {body: body + " ", headers: []string{"Content-MD5", sum}, wantCode: http.StatusBadRequest, want: "BadDigest"},
+		"invalid md5":       {body: body, headers: []string{"Content-MD5", "invalid"}, wantCode: http.StatusBadRequest, want: "InvalidDigest"},
+		"checksum mismatch": {body: body, headers: []string{"X-Amz-Checksum-Crc32", "AAAAAA=="}, wantCode: http.StatusBadRequest, want: "BadDigest"},
+		"too many keys":     {body: tooMany, headers: []string{"Content-MD5", tooManySum}, wantCode: http.StatusBadRequest, want: "MalformedXML"},
+		"no keys":           {body: empty, headers: []string{"Content-MD5", emptySum}, wantCode: http.StatusBadRequest, want: "MalformedXML"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := doRequest(h, http.MethodPost, "/bucket?delete", tc.body, tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected status to be %v got %v %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), "<Code>"+tc.want+"</Code>") && tc.want != "" {
+				t.Errorf("expected code to be %v got %s", tc.want, res.Body.String())
+			}
+		})
+	}
+}
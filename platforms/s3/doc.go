@@ -4,5 +4,92 @@ as a drop in replacement for Amazon S3.  This is not meant to be used
 for production workloads, but as a dev tool to make it easy to seed
 data and inspect new information stored as it is mapped to the file
 system.
+
+Each top-level directory of the served directory is a bucket, and each
+file below a bucket is an object keyed by its path relative to the
+bucket.  Folder objects (keys ending in a slash) are stored as empty
+directories.  State which is not an object, such as the headers and
+user metadata each object was uploaded with and its tags, is kept in the
+hidden .s3 directory at the root of the served directory, under
+.s3/<bucket>/meta/<key>.json.
+
+When versioning is enabled for a bucket, the current version of each
+object remains in the bucket directory, while noncurrent versions and
+delete markers are moved to the hidden directory.
+
+Buckets are addressed path-style (host/bucket/key), or virtual-hosted–style
+(bucket.domain/key) when the Host header is a subdomain of the domain set
+by HH_S3_DOMAIN, which defaults to s3.localhost.
+
+The s3.auth pipeline stage verifies requests are signed with AWS
+Signature Version 4, in either the Authorization header or the query
+string of a presigned URL, using the access keys configured with the
+HH_S3_CREDENTIALS and HH_S3_CREDENTIALS_FILE env variables.  hs adds it
+to the default s3 pipeline when access keys are configured.  Bodies
+streamed using the aws-chunked content encoding are decoded, verifying
+the signature of each chunk when s3.auth is used, and any trailing
+checksum.
+
+The handler also serves the STS AssumeRole, GetSessionToken and
+GetCallerIdentity actions, POSTed as a form to the root of the service and
+signed for the sts service.  They issue temporary credentials whose session
+token seals the credentials and their expiration with the secret of the
+access key which requested them, so s3.auth accepts them, along with
+X-Amz-Security-Token, until they expire or that access key is removed.
+Bucket policies match assumed role sessions by the role's ARN.
+
+S3.Sign and S3.SignURL return presigned URLs, and S3.SignRequest signs an
+*http.Request with the Authorization header, for tests and clients of the
+handler or of any SigV4 service.  The hs presign command prints presigned
+URLs.
+
+Uploads are rejected with BadDigest when their Content-MD5 or
+x-amz-checksum-* header, or trailer, does not match the data received.
+The checksum is stored with the object and returned by GET and HEAD
+requests sent with x-amz-checksum-mode: ENABLED.
+
+Errors are returned as S3 XML error responses, and every response includes
+the x-amz-request-id and x-amz-id-2 headers.  The s3.error pipeline stage
+reports panics as an InternalError, in place of the error stage.
+
+CORS rules are configured per bucket with the PutBucketCors API, and
+preflight and actual requests are evaluated against them as S3 does, so
+the cors pipeline stage should not be used with this handler.  Preflight
+requests are not signed, so they are not verified by s3.auth.
+
+Browser based uploads POST a multipart/form-data form to the bucket.  When
+s3.auth is used the form must include a policy signed with one of the
+configured access keys, such as one returned by S3.SignPostPolicy, and its
+conditions are enforced on the fields and size of the upload.
+
+Bucket policies set with the PutBucketPolicy API allow or deny actions on
+the bucket and its keys, matching principals, action and resource
+wildcards, and the IpAddress, Bool and String* condition operators.  With
+s3.auth, requests which are not signed are anonymous and are denied unless
+a policy allows them, such as a public-read prefix.  Signed requests are
+allowed unless a policy denies them, with each access key matching the
+principal arn:aws:iam::000000000000:user/<access key>.
+
+Buckets configured with the PutBucketNotificationConfiguration API emit
+s3:ObjectCreated:* and s3:ObjectRemoved:* events, filtered by key prefix
+and suffix.  Whatever the destination ARN configured, events are POSTed to
+the Handler's NotificationURL and appended to its NotificationFile as
+JSON lines, set by the HH_S3_NOTIFICATION_URL and HH_S3_NOTIFICATION_FILE
+env variables.
+
+Lifecycle rules set with the PutBucketLifecycleConfiguration API are
+applied by Handler.Sweep, which hs runs every HH_S3_LIFECYCLE_INTERVAL.
+Objects and noncurrent versions are expired by age, prefix, tags and size,
+and incomplete multipart uploads are aborted.  Advancing the Handler's
+Clock lets tests expire objects without waiting days.  hs sets a Clock
+when HH_S3_CLOCK is true, starting HH_S3_CLOCK_OFFSET ahead, which apps
+can read with GET /-/clock and advance with POST /-/clock?advance=72h,
+applying lifecycle rules as of the new time.  The endpoint is not
+authorized, so it should only be enabled for tests.
+
+Objects written with x-amz-server-side-encryption: AES256 (SSE-S3) or
+with a customer key (SSE-C) are stored encrypted with AES-256-GCM.  SSE-S3
+data keys are sealed with a master key kept in .s3/sse.key in Directory,
+while SSE-C objects can only be read with the key they were written with.
 */
 package s3
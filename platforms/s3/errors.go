@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// apiError is an error reported to the client as an S3 error response.
+type apiError struct {
+	Code       string
+	Message    string
+	StatusCode int
+
+	// Details included in SignatureDoesNotMatch responses to help debug
+	// signing clients.
+	AWSAccessKeyID   string
+	StringToSign     string
+	CanonicalRequest string
+}
+
+func (e apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied                      = apiError{Code: "AccessDenied", Message: "Access Denied", StatusCode: http.StatusForbidden}
+	errAuthorizationQueryParametersError = apiError{Code: "AuthorizationQueryParametersError", Message: "Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters.", StatusCode: http.StatusBadRequest}
+	errAuthorizationHeaderMalformed      = apiError{Code: "AuthorizationHeaderMalformed", Message: "The authorization header is malformed.", StatusCode: http.StatusBadRequest}
+	errInvalidAccessKeyID                = apiError{Code: "InvalidAccessKeyId", Message: "The AWS access key ID you provided does not exist in our records.", StatusCode: http.StatusForbidden}
+	errMetadataTooLarge                  = apiError{Code: "MetadataTooLarge", Message: "Your metadata headers exceed the maximum allowed metadata size.", StatusCode: http.StatusBadRequest}
+	errMissingContentSHA256              = apiError{Code: "InvalidRequest", Message: "Missing required header for this request: x-amz-content-sha256", StatusCode: http.StatusBadRequest}
+	errMissingDateHeader                 = apiError{Code: "AccessDenied", Message: "AWS authentication requires a valid Date or x-amz-date header", StatusCode: http.StatusForbidden}
+	errRequestExpired                    = apiError{Code: "AccessDenied", Message: "Request has expired", StatusCode: http.StatusForbidden}
+	errRequestNotValidYet                = apiError{Code: "AccessDenied", Message: "Request is not valid yet", StatusCode: http.StatusForbidden}
+	errRequestTimeTooSkewed              = apiError{Code: "RequestTimeTooSkewed", Message: "The difference between the request time and the current time is too large.", StatusCode: http.StatusForbidden}
+	errSignatureDoesNotMatch             = apiError{Code: "SignatureDoesNotMatch", Message: "The request signature we calculated does not match the signature you provided. Check your key and signing method.", StatusCode: http.StatusForbidden}
+	errUnsupportedAuthorization          = apiError{Code: "InvalidRequest", Message: "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.", StatusCode: http.StatusBadRequest}
+	errContentSHA256Mismatch             = apiError{Code: "XAmzContentSHA256Mismatch", Message: "The provided 'x-amz-content-sha256' header does not match what was computed.", StatusCode: http.StatusBadRequest}
+	errPreconditionFailed                = apiError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold", StatusCode: http.StatusPreconditionFailed}
+	errInvalidRange                      = apiError{Code: "InvalidRange", Message: "The requested range is not satisfiable", StatusCode: http.StatusRequestedRangeNotSatisfiable}
+	errBadDigest                         = apiError{Code: "BadDigest", Message: "The Content-MD5 you specified did not match what we received.", StatusCode: http.StatusBadRequest}
+	errInvalidDigest                     = apiError{Code: "InvalidDigest", Message: "The Content-MD5 you specified was invalid.", StatusCode: http.StatusBadRequest}
+	errInvalidChecksum                   = apiError{Code: "InvalidRequest", Message: "Value for x-amz-checksum header is invalid.", StatusCode: http.StatusBadRequest}
+	errChecksumTypeMismatch              = apiError{Code: "InvalidRequest", Message: "Checksum Type mismatch occurred, expected checksum Type: %s, actual checksum Type: %s", StatusCode: http.StatusBadRequest}
+	errUnsupportedChecksumAlgorithm      = apiError{Code: "InvalidRequest", Message: "Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, CRC64NVME, SHA1, SHA256]", StatusCode: http.StatusBadRequest}
+	errInvalidChecksumType               = apiError{Code: "InvalidRequest", Message: "Value for x-amz-checksum-type header is invalid.", StatusCode: http.StatusBadRequest}
+	errMultipleChecksums                 = apiError{Code: "InvalidRequest", Message: "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.", StatusCode: http.StatusBadRequest}
+	errMissingContentMD5                 = apiError{Code: "InvalidRequest", Message: "Missing required header for this request: Content-MD5", StatusCode: http.StatusBadRequest}
+	errBucketAlreadyOwnedByYou           = apiError{Code: "BucketAlreadyOwnedByYou", Message: "Your previous request to create the named bucket succeeded and you already own it.", StatusCode: http.StatusConflict}
+	errBucketNotEmpty                    = apiError{Code: "BucketNotEmpty", Message: "The bucket you tried to delete is not empty", StatusCode: http.StatusConflict}
+	errEntityTooLarge                    = apiError{Code: "EntityTooLarge", Message: "Your proposed upload exceeds the maximum allowed size", StatusCode: http.StatusBadRequest}
+	errEntityTooSmall                    = apiError{Code: "EntityTooSmall", Message: "Your proposed upload is smaller than the minimum allowed object size.", StatusCode: http.StatusBadRequest}
+	errInternalError                     = apiError{Code: "InternalError", Message: "We encountered an internal error. Please try again.", StatusCode: http.StatusInternalServerError}
+	errInvalidRequest                    = apiError{Code: "InvalidRequest", Message: "Invalid Request", StatusCode: http.StatusBadRequest}
+	errInvalidArgument                   = apiError{Code: "InvalidArgument", Message: "Invalid Argument", StatusCode: http.StatusBadRequest}
+	errInvalidBucketName                 = apiError{Code: "InvalidBucketName", Message: "The specified bucket is not valid.", StatusCode: http.StatusBadRequest}
+	errInvalidContinuationToken          = apiError{Code: "InvalidArgument", Message: "The continuation token provided is incorrect", StatusCode: http.StatusBadRequest}
+	errInvalidCopyRequest                = apiError{Code: "InvalidRequest", Message: "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.", StatusCode: http.StatusBadRequest}
+	errInvalidCopySource                 = apiError{Code: "InvalidArgument", Message: "Copy Source must mention the source bucket and key: sourcebucket/sourcekey", StatusCode: http.StatusBadRequest}
+	errInvalidKey                        = apiError{Code: "InvalidArgument", Message: "The specified key is not valid.", StatusCode: http.StatusBadRequest}
+	errInvalidMetadataDirective          = apiError{Code: "InvalidArgument", Message: "Unknown metadata directive.", StatusCode: http.StatusBadRequest}
+	errInvalidPart                       = apiError{Code: "InvalidPart", Message: "One or more of the specified parts could not be found.  The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.", StatusCode: http.StatusBadRequest}
+	errInvalidPartNumber                 = apiError{Code: "InvalidArgument", Message: "Part number must be an integer between 1 and 10000, inclusive", StatusCode: http.StatusBadRequest}
+	errInvalidPartOrder                  = apiError{Code: "InvalidPartOrder", Message: "The list of parts was not in ascending order. Parts must be ordered by part number.", StatusCode: http.StatusBadRequest}
+	errInvalidVersionID                  = apiError{Code: "InvalidArgument", Message: "Invalid version id specified", StatusCode: http.StatusBadRequest}
+	errKeyTooLong                        = apiError{Code: "KeyTooLongError", Message: "Your key is too long", StatusCode: http.StatusBadRequest}
+	errMalformedXML                      = apiError{Code: "MalformedXML", Message: "The XML you provided was not well-formed or did not validate against our published schema", StatusCode: http.StatusBadRequest}
+	errMethodNotAllowed                  = apiError{Code: "MethodNotAllowed", Message: "The specified method is not allowed against this resource.", StatusCode: http.StatusMethodNotAllowed}
+	errNoSuchBucket                      = apiError{Code: "NoSuchBucket", Message: "The specified bucket does not exist", StatusCode: http.StatusNotFound}
+	errNoSuchKey                         = apiError{Code: "NoSuchKey", Message: "The specified key does not exist.", StatusCode: http.StatusNotFound}
+	errNoSuchUpload                      = apiError{Code: "NoSuchUpload", Message: "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.", StatusCode: http.StatusNotFound}
+	errNoSuchVersion                     = apiError{Code: "NoSuchVersion", Message: "The specified version does not exist.", StatusCode: http.StatusNotFound}
+	errExpiredToken                      = apiError{Code: "ExpiredToken", Message: "The provided token has expired.", StatusCode: http.StatusBadRequest}
+	errInvalidToken                      = apiError{Code: "InvalidToken", Message: "The provided token is malformed or otherwise invalid.", StatusCode: http.StatusBadRequest}
+	errInvalidAction                     = apiError{Code: "InvalidAction", Message: "Could not find operation for version 2011-06-15", StatusCode: http.StatusBadRequest}
+	errMissingAuthenticationToken        = apiError{Code: "MissingAuthenticationToken", Message: "Request is missing Authentication Token", StatusCode: http.StatusForbidden}
+	errValidationError                   = apiError{Code: "ValidationError", Message: "1 validation error detected", StatusCode: http.StatusBadRequest}
+	errInvalidEncryptionMethod           = apiError{Code: "InvalidArgument", Message: "The encryption method specified is not supported", StatusCode: http.StatusBadRequest}
+	errInvalidEncryptionAlgorithm        = apiError{Code: "InvalidEncryptionAlgorithmError", Message: "The encryption request you specified is not valid. Supported value: AES256.", StatusCode: http.StatusBadRequest}
+	errIncompatibleEncryption            = apiError{Code: "InvalidArgument", Message: "Server Side Encryption with Customer provided key is incompatible with the encryption method specified", StatusCode: http.StatusBadRequest}
+	errMissingSSECustomerAlgorithm       = apiError{Code: "InvalidArgument", Message: "Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm.", StatusCode: http.StatusBadRequest}
+	errMissingSSECustomerKey             = apiError{Code: "InvalidArgument", Message: "Requests specifying Server Side Encryption with Customer provided keys must provide an appropriate secret key.", StatusCode: http.StatusBadRequest}
+	errMissingSSECustomerKeyMD5          = apiError{Code: "InvalidArgument", Message: "Requests specifying Server Side Encryption with Customer provided keys must provide the client calculated MD5 of the secret key.", StatusCode: http.StatusBadRequest}
+	errInvalidSSECustomerKey             = apiError{Code: "InvalidArgument", Message: "The secret key was invalid for the specified algorithm.", StatusCode: http.StatusBadRequest}
+	errSSECustomerKeyMD5Mismatch         = apiError{Code: "InvalidArgument", Message: "The calculated MD5 hash of the key did not match the hash that was provided.", StatusCode: http.StatusBadRequest}
+	errSSECustomerKeyRequired            = apiError{Code: "InvalidRequest", Message: "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.", StatusCode: http.StatusBadRequest}
+	errSSENotApplicable                  = apiError{Code: "InvalidRequest", Message: "The encryption parameters are not applicable to this object.", StatusCode: http.StatusBadRequest}
+	errUploadEncryptionRequired          = apiError{Code: "InvalidRequest", Message: "The multipart upload initiate requested encryption. Subsequent part requests must include the appropriate encryption parameters.", StatusCode: http.StatusBadRequest}
+	errNoSuchBucketPolicy                = apiError{Code: "NoSuchBucketPolicy", Message: "The bucket policy does not exist", StatusCode: http.StatusNotFound}
+	errMalformedPolicy                   = apiError{Code: "MalformedPolicy", Message: "Policies must be valid JSON and the first byte must be '{'", StatusCode: http.StatusBadRequest}
+	errNoSuchLifecycleConfiguration      = apiError{Code: "NoSuchLifecycleConfiguration", Message: "The lifecycle configuration does not exist", StatusCode: http.StatusNotFound}
+	errNoSuchCORSConfiguration           = apiError{Code: "NoSuchCORSConfiguration", Message: "The CORS configuration does not exist", StatusCode: http.StatusNotFound}
+	errInvalidCORSMethod                 = apiError{Code: "InvalidRequest", Message: "Found unsupported HTTP method in CORS config. Unsupported method is ", StatusCode: http.StatusBadRequest}
+	errInvalidCORSWildcard               = apiError{Code: "InvalidRequest", Message: "CORS rules can not have more than one wildcard.", StatusCode: http.StatusBadRequest}
+	errInvalidCORSRequestMethod          = apiError{Code: "BadRequest", Message: "Invalid Access-Control-Request-Method: ", StatusCode: http.StatusBadRequest}
+	errCORSMissingOrigin                 = apiError{Code: "BadRequest", Message: "Insufficient information. Origin request header needed.", StatusCode: http.StatusBadRequest}
+	errCORSBucketNotFound                = apiError{Code: "AccessForbidden", Message: "CORSResponse: Bucket not found", StatusCode: http.StatusForbidden}
+	errCORSNotEnabled                    = apiError{Code: "AccessForbidden", Message: "CORSResponse: CORS is not enabled for this bucket.", StatusCode: http.StatusForbidden}
+	errCORSNotAllowed                    = apiError{Code: "AccessForbidden", Message: "CORSResponse: This CORS request is not allowed. This is usually because the evalution of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.", StatusCode: http.StatusForbidden}
+	errNoSuchTagSet                      = apiError{Code: "NoSuchTagSet", Message: "The TagSet does not exist", StatusCode: http.StatusNotFound}
+	errInvalidTag                        = apiError{Code: "InvalidTag", Message: "The tag provided was not a valid tag.", StatusCode: http.StatusBadRequest}
+	errDuplicateTagKeys                  = apiError{Code: "InvalidTag", Message: "Cannot provide multiple Tags with the same key", StatusCode: http.StatusBadRequest}
+	errInvalidTaggingHeader              = apiError{Code: "InvalidArgument", Message: "The header 'x-amz-tagging' shall be encoded as UTF-8 then URLEncoded URL query parameters without tag name duplicates.", StatusCode: http.StatusBadRequest}
+	errInvalidTaggingDirective           = apiError{Code: "InvalidArgument", Message: "Unknown tagging directive.", StatusCode: http.StatusBadRequest}
+	errIncompleteBody                    = apiError{Code: "IncompleteBody", Message: "You did not provide the number of bytes specified by the Content-Length HTTP header.", StatusCode: http.StatusBadRequest}
+	errInvalidTrailer                    = apiError{Code: "InvalidRequest", Message: "The value specified in the x-amz-trailer header is not supported", StatusCode: http.StatusBadRequest}
+	errMalformedPOSTRequest              = apiError{Code: "MalformedPOSTRequest", Message: "The body of your POST request is not well-formed multipart/form-data.", StatusCode: http.StatusBadRequest}
+	errMaxPostPreDataLengthExceeded      = apiError{Code: "MaxPostPreDataLengthExceededError", Message: "Your POST request fields preceding the upload file were too large.", StatusCode: http.StatusBadRequest}
+	errMissingPOSTFile                   = apiError{Code: "InvalidArgument", Message: "POST requires exactly one file upload per request.", StatusCode: http.StatusBadRequest}
+	errMissingPOSTKey                    = apiError{Code: "InvalidArgument", Message: "Bucket POST must contain a field named 'key'.  If it is specified, please check the order of the fields.", StatusCode: http.StatusBadRequest}
+	errPolicyRequired                    = apiError{Code: "AccessDenied", Message: "Bucket POST must contain a field named 'policy'.", StatusCode: http.StatusForbidden}
+	errInvalidPolicyDocument             = apiError{Code: "InvalidPolicyDocument", Message: "Invalid Policy: Invalid JSON.", StatusCode: http.StatusBadRequest}
+	errNotImplemented                    = apiError{Code: "NotImplemented", Message: "A header you provided implies functionality that is not implemented", StatusCode: http.StatusNotImplemented}
+
+	// errFolderContent and errKeyConflict are limits of mapping keys to
+	// the file system which S3 itself does not have.
+	errFolderContent = apiError{Code: "InvalidRequest", Message: "Folder objects, whose keys end in a slash, can not have any content.", StatusCode: http.StatusBadRequest}
+	errKeyConflict   = apiError{Code: "InvalidRequest", Message: "The key conflicts with an existing object, objects can not be stored below the key of another object.", StatusCode: http.StatusConflict}
+)
+
+type errorResponse struct {
+	XMLName          xml.Name `xml:"Error"`
+	Code             string   `xml:"Code"`
+	Message          string   `xml:"Message"`
+	Resource         string   `xml:"Resource,omitempty"`
+	AWSAccessKeyID   string   `xml:"AWSAccessKeyId,omitempty"`
+	StringToSign     string   `xml:"StringToSign,omitempty"`
+	CanonicalRequest string   `xml:"CanonicalRequest,omitempty"`
+	RequestID        string   `xml:"RequestId,omitempty"`
+	HostID           string   `xml:"HostId,omitempty"`
+}
+
+// Error recovers from panics in later stages, responding with an S3
+// InternalError rather than the bare status written by middleware.Error.
+// It also sets the request ID headers S3 includes on every response.
+func Error(h http.Handler) http.Handler {
+	fn := func(rw http.ResponseWriter, r *http.Request) {
+		setRequestID(rw)
+		defer func() {
+			if v := recover(); v != nil {
+				os.Stderr.Write([]byte(fmt.Sprintf("ERROR: %v\n", v)))
+				writeError(rw, r, errInternalError)
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// setRequestID sets the x-amz-request-id and x-amz-id-2 headers, unless an
+// earlier stage already has.  The ID set by the request_id stage is reused
+// so the two can be correlated.
+func setRequestID(w http.ResponseWriter) {
+	header := w.Header()
+	if header.Get("X-Amz-Request-Id") != "" {
+		return
+	}
+
+	id := header.Get("X-Request-ID")
+	if id == "" {
+		id = strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	sum := sha256.Sum256([]byte(id))
+
+	header.Set("X-Amz-Request-Id", strings.ToUpper(id))
+	header.Set("X-Amz-Id-2", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// writeError writes err as an S3 error response.  Responses to HEAD requests
+// only include the status code.
+func writeError(w http.ResponseWriter, r *http.Request, err apiError) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.StatusCode)
+		return
+	}
+
+	writeXML(w, err.StatusCode, errorResponse{
+		Code:             err.Code,
+		Message:          err.Message,
+		Resource:         r.URL.Path,
+		AWSAccessKeyID:   err.AWSAccessKeyID,
+		StringToSign:     err.StringToSign,
+		CanonicalRequest: err.CanonicalRequest,
+		RequestID:        w.Header().Get("X-Amz-Request-Id"),
+		HostID:           w.Header().Get("X-Amz-Id-2"),
+	})
+}
@@ -0,0 +1,88 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorResponses(t *testing.T) {
+	tests := map[string]struct {
+		method   string
+		target   string
+		body     string
+		headers  []string
+		wantCode int
+		want     string
+	}{
+		"no such bucket":     {method: http.MethodGet, target: "/missing?list-type=2", wantCode: http.StatusNotFound, want: "NoSuchBucket"},
+		"no such key":        {method: http.MethodGet, target: "/bucket/missing", wantCode: http.StatusNotFound, want: "NoSuchKey"},
+		"no such upload":     {method: http.MethodGet, target: "/bucket/key?uploadId=missing", wantCode: http.StatusNotFound, want: "NoSuchUpload"},
+		"no such version":    {method: http.MethodGet, target: "/bucket/key?versionId=0123456789abcdef0123456789abcdef", wantCode: http.StatusNotFound, want: "NoSuchVersion"},
+		"invalid version":    {method: http.MethodGet, target: "/bucket/key?versionId=bad", wantCode: http.StatusBadRequest, want: "InvalidArgument"},
+		"invalid bucket":     {method: http.MethodPut, target: "/Bucket", wantCode: http.StatusBadRequest, want: "InvalidBucketName"},
+		"bucket exists":      {method: http.MethodPut, target: "/bucket", wantCode: http.StatusConflict, want: "BucketAlreadyOwnedByYou"},
+		"bucket not empty":   {method: http.MethodDelete, target: "/bucket", wantCode: http.StatusConflict, want: "BucketNotEmpty"},
+		"key too long":       {method: http.MethodGet, target: "/bucket/" + strings.Repeat("k", maxKeyLength+1), wantCode: http.StatusBadRequest, want: "KeyTooLongError"},
+		"invalid key":        {method: http.MethodGet, target: "/bucket/a//b", wantCode: http.StatusBadRequest, want: "InvalidArgument"},
+		"method not allowed": {method: http.MethodPatch, target: "/bucket/key", wantCode: http.StatusMethodNotAllowed, want: "MethodNotAllowed"},
+		"malformed xml":      {method: http.MethodPut, target: "/bucket?versioning", body: "<VersioningConfiguration>", wantCode: http.StatusBadRequest, want: "MalformedXML"},
+		"key conflict":       {method: http.MethodPut, target: "/bucket/key/child", body: "data", wantCode: http.StatusConflict, want: "InvalidRequest"},
+		"invalid range":      {method: http.MethodGet, target: "/bucket/key", headers: []string{"Range", "bytes=100-"}, wantCode: http.StatusRequestedRangeNotSatisfiable, want: "InvalidRange"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			doRequest(h, http.MethodPut, "/bucket/key", "data")
+
+			res := doRequest(h, tc.method, tc.target, tc.body, tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+
+			got := errorResponse{}
+			if err := xml.Unmarshal(res.Body.Bytes(), &got); err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			if got.Code != tc.want {
+				t.Errorf("expected code to be %v got %v", tc.want, got.Code)
+			}
+			if got.RequestID == "" || got.RequestID != res.Header().Get("X-Amz-Request-Id") {
+				t.Errorf("expected request id to match the X-Amz-Request-Id header got %q", got.RequestID)
+			}
+			if got.HostID == "" || got.HostID != res.Header().Get("X-Amz-Id-2") {
+				t.Errorf("expected host id to match the X-Amz-Id-2 header got %q", got.HostID)
+			}
+		})
+	}
+}
+
+func TestErrorMiddleware(t *testing.T) {
+	panics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := Error(panics)
+
+	req := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "abc123")
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status to be %v got %v", http.StatusInternalServerError, rec.Code)
+	}
+	if got := rec.Header().Get("X-Amz-Request-Id"); got != "ABC123" {
+		t.Errorf("expected request id to be %v got %v", "ABC123", got)
+	}
+
+	got := errorResponse{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if got.Code != "InternalError" {
+		t.Errorf("expected code to be %v got %v", "InternalError", got.Code)
+	}
+}
@@ -1,13 +1,390 @@
 package s3
 
 import (
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+var timeNow = time.Now
+
+// systemDir is the hidden directory, relative to Handler.Directory, used to
+// store state which should never be served as a bucket or object.
+const systemDir = ".s3"
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// DefaultDomain is the domain buckets are virtual-hosted on when
+// HH_S3_DOMAIN is not set.
+const DefaultDomain = "s3.localhost"
+
+// Handler responds to S3 API requests.  Each top-level directory in
+// Directory is a bucket, and every file below it is an object whose key is
+// the path relative to the bucket.
+//
+// Requests to a subdomain of Domain use virtual-hosted–style addressing,
+// where the bucket is taken from the Host header, all other requests use
+// path-style addressing.
+//
+// Events matching a bucket's notification configuration are POSTed to
+// NotificationURL, and appended to NotificationFile, when they are set.
+//
+// Requests are denied when the bucket policy denies them.  Anonymous
+// requests passed on by Auth are denied unless the bucket policy allows
+// them.
 type Handler struct {
-	Directory string
+	Directory        string
+	Domain           string
+	NotificationURL  string
+	NotificationFile string
+
+	// Clock is the time lifecycle rules are applied at, defaulting to the
+	// current time.  When set, it is reported and advanced at /-/clock.
+	Clock *Clock
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("TODO: implement a mock S3 API which read and writes to the filesystem."))
+	setRequestID(w)
+	bucket, key := h.parseRequest(r)
+
+	if err := decodeStreamingBody(r); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if h.Clock != nil && r.URL.Path == clockPath {
+		h.serveClock(w, r)
+		return
+	}
+
+	if isSTSRequest(r, h.Domain) {
+		h.serveSTS(w, r)
+		return
+	}
+
+	if bucket == "" {
+		if err := h.authorize(r, "s3:ListAllMyBuckets", "", ""); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		h.serveService(w, r)
+		return
+	}
+
+	if !validBucketName(bucket) {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		h.preflight(w, r, bucket)
+		return
+	}
+	if r.Header.Get("Origin") != "" {
+		h.setCORSHeaders(w, r, bucket)
+	}
+
+	if action := requestAction(r, key); action != "" {
+		if err := h.authorize(r, action, bucket, key); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+
+	if key == "" {
+		h.serveBucket(w, r, bucket)
+		return
+	}
+
+	if err := validKey(key); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	h.serveObject(w, r, bucket, key)
+}
+
+func (h Handler) serveService(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listBuckets(w, r)
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+func (h Handler) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPut:
+		if !hasSubresource(query) {
+			h.createBucket(w, r, bucket)
+			return
+		}
+		if !h.bucketExists(bucket) {
+			writeError(w, r, errNoSuchBucket)
+			return
+		}
+		switch {
+		case query.Has("cors"):
+			h.putBucketCors(w, r, bucket)
+		case query.Has("lifecycle"):
+			h.putBucketLifecycle(w, r, bucket)
+		case query.Has("notification"):
+			h.putBucketNotification(w, r, bucket)
+		case query.Has("policy"):
+			h.putBucketPolicy(w, r, bucket)
+		case query.Has("tagging"):
+			h.putBucketTagging(w, r, bucket)
+		default:
+			h.putBucketVersioning(w, r, bucket)
+		}
+	case http.MethodGet:
+		if !h.bucketExists(bucket) {
+			writeError(w, r, errNoSuchBucket)
+			return
+		}
+		switch {
+		case query.Has("uploads"):
+			h.listMultipartUploads(w, r, bucket)
+		case query.Has("cors"):
+			h.getBucketCors(w, r, bucket)
+		case query.Has("lifecycle"):
+			h.getBucketLifecycle(w, r, bucket)
+		case query.Has("notification"):
+			h.getBucketNotification(w, r, bucket)
+		case query.Has("policy"):
+			h.getBucketPolicy(w, r, bucket)
+		case query.Has("tagging"):
+			h.getBucketTagging(w, r, bucket)
+		case query.Has("versioning"):
+			h.getBucketVersioning(w, r, bucket)
+		case query.Has("versions"):
+			h.listObjectVersions(w, r, bucket)
+		case query.Get("list-type") == "2":
+			h.listObjectsV2(w, r, bucket)
+		default:
+			h.listObjects(w, r, bucket)
+		}
+	case http.MethodPost:
+		if !h.bucketExists(bucket) {
+			writeError(w, r, errNoSuchBucket)
+			return
+		}
+		switch {
+		case query.Has("delete"):
+			h.deleteObjects(w, r, bucket)
+		case isPostObject(r):
+			h.postObject(w, r, bucket)
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+	case http.MethodHead:
+		h.headBucket(w, r, bucket)
+	case http.MethodDelete:
+		if !hasSubresource(query) {
+			h.deleteBucket(w, r, bucket)
+			return
+		}
+		if !h.bucketExists(bucket) {
+			writeError(w, r, errNoSuchBucket)
+			return
+		}
+		switch {
+		case query.Has("cors"):
+			h.deleteBucketCors(w, r, bucket)
+		case query.Has("lifecycle"):
+			h.deleteBucketLifecycle(w, r, bucket)
+		case query.Has("policy"):
+			h.deleteBucketPolicy(w, r, bucket)
+		case query.Has("tagging"):
+			h.deleteBucketTagging(w, r, bucket)
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+	default:
+		writeError(w, r, errNotImplemented)
+	}
+}
+
+// subresources are the query parameters which address the configuration of
+// a bucket, rather than the bucket itself.
+var subresources = []string{"cors", "lifecycle", "notification", "policy", "tagging", "versioning"}
+
+func hasSubresource(query url.Values) bool {
+	return slices.ContainsFunc(subresources, query.Has)
+}
+
+func (h Handler) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !h.bucketExists(bucket) {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			h.createMultipartUpload(w, r, bucket, key)
+		case query.Has("uploadId"):
+			h.completeMultipartUpload(w, r, bucket, key)
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+	case http.MethodPut:
+		copySource := r.Header.Get("X-Amz-Copy-Source") != ""
+		switch {
+		case query.Has("uploadId") && copySource:
+			h.uploadPartCopy(w, r, bucket, key)
+		case query.Has("uploadId"):
+			h.uploadPart(w, r, bucket, key)
+		case query.Has("tagging"):
+			h.putObjectTagging(w, r, bucket, key)
+		case copySource:
+			h.copyObject(w, r, bucket, key)
+		default:
+			h.putObject(w, r, bucket, key)
+		}
+	case http.MethodGet:
+		switch {
+		case query.Has("uploadId"):
+			h.listParts(w, r, bucket, key)
+		case query.Has("tagging"):
+			h.getObjectTagging(w, r, bucket, key)
+		default:
+			h.getObject(w, r, bucket, key)
+		}
+	case http.MethodHead:
+		h.headObject(w, r, bucket, key)
+	case http.MethodDelete:
+		switch {
+		case query.Has("uploadId"):
+			h.abortMultipartUpload(w, r, bucket, key)
+		case query.Has("tagging"):
+			h.deleteObjectTagging(w, r, bucket, key)
+		default:
+			h.deleteObject(w, r, bucket, key)
+		}
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+// writeXML writes v as the XML body of the response.
+func writeXML(w http.ResponseWriter, statusCode int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(data)))
+	w.WriteHeader(statusCode)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// parseRequest returns the bucket and key addressed by a request.
+func (h Handler) parseRequest(r *http.Request) (string, string) {
+	if bucket, ok := virtualHostBucket(h.Domain, r.Host); ok {
+		return bucket, strings.TrimPrefix(r.URL.Path, "/")
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return bucket, key
+}
+
+// requestScheme returns the scheme a request was sent with, https when it
+// was received over TLS or forwarded by a proxy which was.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+// virtualHostBucket returns the bucket named by host, if host is a subdomain
+// of domain.
+func virtualHostBucket(domain, host string) (string, bool) {
+	if domain == "" {
+		return "", false
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	bucket, ok := strings.CutSuffix(host, "."+strings.ToLower(domain))
+	if !ok || bucket == "" {
+		return "", false
+	}
+	return bucket, true
+}
+
+func validBucketName(name string) bool {
+	if !bucketNamePattern.MatchString(name) {
+		return false
+	}
+	return !strings.Contains(name, "..")
+}
+
+// maxKeyLength is the maximum length in bytes of an object key.
+const maxKeyLength = 1024
+
+// validKey returns the apiError S3 responds with when key can not be mapped
+// onto the file system, or nil if it is valid.  Keys which are too long,
+// which would escape the bucket, or which contain empty path segments, are
+// rejected.  A single trailing slash is allowed for folder objects.
+func validKey(key string) error {
+	if len(key) > maxKeyLength {
+		return errKeyTooLong
+	}
+	if strings.ContainsRune(key, 0) {
+		return errInvalidKey
+	}
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return errInvalidKey
+		}
+	}
+	return nil
+}
+
+func (h Handler) bucketPath(bucket string) string {
+	return filepath.Join(h.Directory, bucket)
+}
+
+func (h Handler) objectPath(bucket, key string) string {
+	return filepath.Join(h.bucketPath(bucket), filepath.FromSlash(key))
+}
+
+func (h Handler) systemPath(elem ...string) string {
+	return filepath.Join(append([]string{h.Directory, systemDir}, elem...)...)
+}
+
+func (h Handler) bucketExists(bucket string) bool {
+	info, err := os.Stat(h.bucketPath(bucket))
+	return err == nil && info.IsDir()
+}
+
+// isNotExist reports whether err indicates the file, or one of its parents,
+// does not exist as expected.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
 }
@@ -0,0 +1,344 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doRequest sends a request directly to h and returns the recorded response.
+func doRequest(h http.Handler, method, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// newTestHandler returns a handler serving a temp directory containing the
+// given buckets.
+func newTestHandler(t *testing.T, buckets ...string) Handler {
+	t.Helper()
+	dir := t.TempDir()
+	for _, b := range buckets {
+		if err := os.Mkdir(filepath.Join(dir, b), 0755); err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+	}
+	return Handler{Directory: dir}
+}
+
+// setTimeNow fixes timeNow at now for the duration of a test.
+func setTimeNow(t *testing.T, now time.Time) {
+	t.Helper()
+	preserveTimeNow := timeNow
+	t.Cleanup(func() {
+		timeNow = preserveTimeNow
+	})
+	timeNow = func() time.Time {
+		return now
+	}
+}
+
+// setTestCredentials configures Auth with the test access key, followed by
+// any other ACCESS_KEY_ID:SECRET_ACCESS_KEY pairs, for the duration of a
+// test.
+func setTestCredentials(t *testing.T, others ...string) {
+	t.Helper()
+	t.Setenv("HH_S3_CREDENTIALS", strings.Join(append([]string{testAccessKeyID + ":" + testSecret}, others...), ","))
+}
+
+func TestObjectCRUD(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodPut, "/bucket/dir/hello.txt", "hello world")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected put status to be %v got %v", http.StatusOK, res.Code)
+	}
+	wantETag := `"5eb63bbbe01eeed093cb22bb8f5acdc3"`
+	if got := res.Header().Get("ETag"); got != wantETag {
+		t.Errorf("expected put etag to be %v got %v", wantETag, got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.Directory, "bucket", "dir", "hello.txt"))
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("expected file contents to be %q got %q", "hello world", data)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/dir/hello.txt", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected get status to be %v got %v", http.StatusOK, res.Code)
+	}
+	if res.Body.String() != "hello world" {
+		t.Errorf("expected get body to be %q got %q", "hello world", res.Body.String())
+	}
+	if got := res.Header().Get("ETag"); got != wantETag {
+		t.Errorf("expected get etag to be %v got %v", wantETag, got)
+	}
+
+	res = doRequest(h, http.MethodHead, "/bucket/dir/hello.txt", "")
+	if got := res.Header().Get("Content-Length"); got != "11" {
+		t.Errorf("expected head content length to be %v got %v", "11", got)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/dir/hello.txt", "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected delete status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+	if _, err := os.Stat(filepath.Join(h.Directory, "bucket", "dir")); !os.IsNotExist(err) {
+		t.Errorf("expected empty parent directory to be removed, got %v", err)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/dir/hello.txt", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected get status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestObjectRequests(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		"put missing bucket":     {method: http.MethodPut, target: "/missing/key", body: "data", want: http.StatusNotFound},
+		"get missing key":        {method: http.MethodGet, target: "/bucket/missing", want: http.StatusNotFound},
+		"head missing key":       {method: http.MethodHead, target: "/bucket/missing", want: http.StatusNotFound},
+		"delete missing key":     {method: http.MethodDelete, target: "/bucket/missing", want: http.StatusNoContent},
+		"put folder":             {method: http.MethodPut, target: "/bucket/folder/", want: http.StatusOK},
+		"put folder with data":   {method: http.MethodPut, target: "/bucket/folder/", body: "data", want: http.StatusBadRequest},
+		"put parent traversal":   {method: http.MethodPut, target: "/bucket/a/../../b", body: "data", want: http.StatusBadRequest},
+		"put empty segment":      {method: http.MethodPut, target: "/bucket/a//b", body: "data", want: http.StatusBadRequest},
+		"put invalid bucket":     {method: http.MethodPut, target: "/Bucket/key", body: "data", want: http.StatusBadRequest},
+		"get directory as key":   {method: http.MethodGet, target: "/bucket/dir", want: http.StatusNotFound},
+		"put key below file":     {method: http.MethodPut, target: "/bucket/file/key", body: "data", want: http.StatusConflict},
+		"put key over directory": {method: http.MethodPut, target: "/bucket/dir", body: "data", want: http.StatusConflict},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			os.MkdirAll(filepath.Join(h.Directory, "bucket", "dir", "sub"), 0755)
+			os.WriteFile(filepath.Join(h.Directory, "bucket", "file"), []byte("data"), 0644)
+
+			res := doRequest(h, tc.method, tc.target, tc.body)
+			if res.Code != tc.want {
+				t.Errorf("expected status to be %v got %v", tc.want, res.Code)
+			}
+		})
+	}
+}
+
+func TestBucketRequests(t *testing.T) {
+	h := newTestHandler(t)
+
+	res := doRequest(h, http.MethodPut, "/bucket", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected create status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket", "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected second create status to be %v got %v", http.StatusConflict, res.Code)
+	}
+
+	res = doRequest(h, http.MethodHead, "/bucket", "")
+	if res.Code != http.StatusOK {
+		t.Errorf("expected head status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	doRequest(h, http.MethodPut, "/bucket/key", "data")
+	res = doRequest(h, http.MethodDelete, "/bucket", "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected delete of non-empty bucket status to be %v got %v", http.StatusConflict, res.Code)
+	}
+
+	doRequest(h, http.MethodDelete, "/bucket/key", "")
+	res = doRequest(h, http.MethodDelete, "/bucket", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected delete status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+
+	res = doRequest(h, http.MethodHead, "/bucket", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected head status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := map[string]struct {
+		url        string
+		wantBucket string
+		wantKey    string
+	}{
+		"path style":                  {url: "http://localhost:8000/bucket/dir/key", wantBucket: "bucket", wantKey: "dir/key"},
+		"path style on domain":        {url: "http://s3.localhost:8000/bucket/key", wantBucket: "bucket", wantKey: "key"},
+		"virtual hosted":              {url: "http://bucket.s3.localhost:8000/dir/key", wantBucket: "bucket", wantKey: "dir/key"},
+		"virtual hosted without port": {url: "http://bucket.s3.localhost/key", wantBucket: "bucket", wantKey: "key"},
+		"virtual hosted dotted":       {url: "http://my.bucket.s3.localhost/key", wantBucket: "my.bucket", wantKey: "key"},
+		"virtual hosted bucket":       {url: "http://bucket.s3.localhost/", wantBucket: "bucket", wantKey: ""},
+		"virtual hosted mixed case":   {url: "http://bucket.S3.Localhost/key", wantBucket: "bucket", wantKey: "key"},
+		"other domain":                {url: "http://bucket.example.com/other/key", wantBucket: "other", wantKey: "key"},
+	}
+
+	h := Handler{Domain: "s3.localhost"}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bucket, key := h.parseRequest(httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if bucket != tc.wantBucket || key != tc.wantKey {
+				t.Errorf("expected %v/%v got %v/%v", tc.wantBucket, tc.wantKey, bucket, key)
+			}
+		})
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	tests := map[string]struct {
+		key  string
+		want error
+	}{
+		"key":            {key: "dir/key"},
+		"folder":         {key: "dir/"},
+		"max length":     {key: strings.Repeat("k", maxKeyLength)},
+		"too long":       {key: strings.Repeat("k", maxKeyLength+1), want: errKeyTooLong},
+		"too long dots":  {key: strings.Repeat("../", maxKeyLength), want: errKeyTooLong},
+		"empty segment":  {key: "dir//key", want: errInvalidKey},
+		"parent segment": {key: "dir/../key", want: errInvalidKey},
+		"null":           {key: "k\x00", want: errInvalidKey},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := validKey(tc.key); got != tc.want {
+				t.Errorf("expected %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	tests := map[string]struct {
+		url     string
+		headers []string
+		want    string
+	}{
+		"http":            {url: "http://localhost:8000/", want: "http"},
+		"tls":             {url: "https://localhost:8000/", want: "https"},
+		"forwarded https": {url: "http://localhost:8000/", headers: []string{"X-Forwarded-Proto", "https"}, want: "https"},
+		"forwarded http":  {url: "http://localhost:8000/", headers: []string{"X-Forwarded-Proto", "http"}, want: "http"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			for i := 0; i+1 < len(tc.headers); i += 2 {
+				r.Header.Set(tc.headers[i], tc.headers[i+1])
+			}
+			if got := requestScheme(r); got != tc.want {
+				t.Errorf("expected %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVirtualHostedObject(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.Domain = "s3.localhost"
+
+	res := doRequest(h, http.MethodPut, "http://bucket.s3.localhost:8000/key", "data")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected put status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	res = doRequest(h, http.MethodGet, "http://localhost:8000/bucket/key", "")
+	if res.Body.String() != "data" {
+		t.Errorf("expected path style get body to be %q got %q", "data", res.Body.String())
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodPut, "/bucket/report", "a,b,c",
+		"Content-Type", "text/csv",
+		"Cache-Control", "max-age=60",
+		"Content-Disposition", `attachment; filename="report.csv"`,
+		"X-Amz-Meta-Owner", "Finance",
+	)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected put status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	tests := map[string]struct {
+		target string
+		want   map[string]string
+	}{
+		"stored": {
+			target: "/bucket/report",
+			want: map[string]string{
+				"Content-Type":        "text/csv",
+				"Cache-Control":       "max-age=60",
+				"Content-Disposition": `attachment; filename="report.csv"`,
+				"X-Amz-Meta-Owner":    "Finance",
+			},
+		},
+		"overridden": {
+			target: "/bucket/report?response-content-type=text/plain&response-cache-control=no-cache",
+			want: map[string]string{
+				"Content-Type":     "text/plain",
+				"Cache-Control":    "no-cache",
+				"X-Amz-Meta-Owner": "Finance",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				res := doRequest(h, method, tc.target, "")
+				for header, want := range tc.want {
+					if got := res.Header().Get(header); got != want {
+						t.Errorf("%s: expected %v to be %q got %q", method, header, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestObjectMetadataDetected(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	os.WriteFile(filepath.Join(h.Directory, "bucket", "page.html"), []byte("<html><body></body></html>"), 0644)
+
+	res := doRequest(h, http.MethodHead, "/bucket/page.html", "")
+	if got := res.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("expected detected content type got %q", got)
+	}
+}
+
+func TestObjectMetadataTooLarge(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodPut, "/bucket/key", "data", "X-Amz-Meta-Large", strings.Repeat("a", 2048))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected status to be %v got %v", http.StatusBadRequest, res.Code)
+	}
+	if _, err := os.Stat(filepath.Join(h.Directory, "bucket", "key")); !os.IsNotExist(err) {
+		t.Errorf("expected object to not be stored, got %v", err)
+	}
+}
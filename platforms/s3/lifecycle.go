@@ -0,0 +1,494 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLifecycleRules is the maximum number of rules in a bucket's lifecycle
+// configuration.
+const maxLifecycleRules = 1000
+
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration" json:"-"`
+	Xmlns   string          `xml:"xmlns,attr,omitempty" json:"-"`
+	Rules   []lifecycleRule `xml:"Rule" json:"rules"`
+}
+
+type lifecycleRule struct {
+	ID     string           `xml:"ID,omitempty" json:"id,omitempty"`
+	Status string           `xml:"Status" json:"status"`
+	Filter *lifecycleFilter `xml:"Filter" json:"filter,omitempty"`
+	// Prefix is the deprecated form of Filter.
+	Prefix *string `xml:"Prefix" json:"prefix,omitempty"`
+
+	Expiration                     *lifecycleExpiration      `xml:"Expiration" json:"expiration,omitempty"`
+	NoncurrentVersionExpiration    *noncurrentExpiration     `xml:"NoncurrentVersionExpiration" json:"noncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *abortIncompleteMultipart `xml:"AbortIncompleteMultipartUpload" json:"abortIncompleteMultipartUpload,omitempty"`
+}
+
+type lifecycleFilter struct {
+	Prefix                *string       `xml:"Prefix" json:"prefix,omitempty"`
+	Tag                   *tag          `xml:"Tag" json:"tag,omitempty"`
+	ObjectSizeGreaterThan *int64        `xml:"ObjectSizeGreaterThan" json:"objectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64        `xml:"ObjectSizeLessThan" json:"objectSizeLessThan,omitempty"`
+	And                   *lifecycleAnd `xml:"And" json:"and,omitempty"`
+}
+
+type lifecycleAnd struct {
+	Prefix                string `xml:"Prefix,omitempty" json:"prefix,omitempty"`
+	Tags                  []tag  `xml:"Tag" json:"tags,omitempty"`
+	ObjectSizeGreaterThan *int64 `xml:"ObjectSizeGreaterThan" json:"objectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64 `xml:"ObjectSizeLessThan" json:"objectSizeLessThan,omitempty"`
+}
+
+type lifecycleExpiration struct {
+	Days                      *int   `xml:"Days" json:"days,omitempty"`
+	Date                      string `xml:"Date,omitempty" json:"date,omitempty"`
+	ExpiredObjectDeleteMarker *bool  `xml:"ExpiredObjectDeleteMarker" json:"expiredObjectDeleteMarker,omitempty"`
+}
+
+type noncurrentExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays" json:"noncurrentDays"`
+}
+
+type abortIncompleteMultipart struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation" json:"daysAfterInitiation"`
+}
+
+// Clock is the time used to apply lifecycle rules, which can be advanced so
+// tests do not need to wait days for objects to expire.  The zero value
+// reports the current time.
+type Clock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+// Now returns the current time plus the duration the clock was advanced.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return timeNow().Add(c.offset)
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+}
+
+// clockPath is where Handler reports and advances its Clock, for tests of
+// apps running against hs.  Bucket names can not start with a dash, so it
+// never names a bucket.
+const clockPath = "/-/clock"
+
+type clockStatus struct {
+	Now string `json:"now"`
+}
+
+// serveClock returns the time of the Handler's Clock, after advancing it by
+// the duration in the advance query parameter of a POST, such as 72h, and
+// applying lifecycle rules as of the new time.
+func (h Handler) serveClock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d, err := time.ParseDuration(r.URL.Query().Get("advance"))
+		if err != nil || d <= 0 {
+			err := errInvalidArgument
+			err.Message = "advance must be a positive duration"
+			writeError(w, r, err)
+			return
+		}
+		h.Clock.Advance(d)
+		if err := h.Sweep(); err != nil {
+			log.Printf("s3: applying lifecycle rules: %v", err)
+			writeError(w, r, errInternalError)
+			return
+		}
+	default:
+		writeError(w, r, errMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(clockStatus{Now: h.Clock.Now().UTC().Format(time.RFC3339)})
+}
+
+func (h Handler) putBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	config := lifecycleConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	if err := validLifecycleConfiguration(config); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if err := h.writeJSON(h.systemPath(bucket, "lifecycle.json"), config); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) getBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := h.readLifecycleConfiguration(bucket)
+	if err != nil {
+		if isNotExist(err) {
+			writeError(w, r, errNoSuchLifecycleConfiguration)
+			return
+		}
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	config.Xmlns = xmlns
+	writeXML(w, http.StatusOK, config)
+}
+
+func (h Handler) deleteBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := os.Remove(h.systemPath(bucket, "lifecycle.json")); err != nil && !isNotExist(err) {
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) readLifecycleConfiguration(bucket string) (lifecycleConfiguration, error) {
+	config := lifecycleConfiguration{}
+	err := readJSON(h.systemPath(bucket, "lifecycle.json"), &config)
+	return config, err
+}
+
+// validLifecycleConfiguration returns the apiError S3 responds with when
+// config is rejected, or nil if it is valid.
+func validLifecycleConfiguration(config lifecycleConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > maxLifecycleRules {
+		return errMalformedXML
+	}
+
+	ids := map[string]bool{}
+	for _, rule := range config.Rules {
+		if err := validLifecycleRule(rule); err != nil {
+			return err
+		}
+		if rule.ID != "" && ids[rule.ID] {
+			err := errInvalidArgument
+			err.Message = "Rule ID must be unique. Found same ID for more than one rule"
+			return err
+		}
+		ids[rule.ID] = true
+	}
+	return nil
+}
+
+func validLifecycleRule(rule lifecycleRule) error {
+	if rule.Status != "Enabled" && rule.Status != "Disabled" {
+		return errMalformedXML
+	}
+	if rule.Filter != nil && rule.Prefix != nil {
+		return errMalformedXML
+	}
+	if rule.Filter != nil && !rule.Filter.valid() {
+		return errMalformedXML
+	}
+
+	invalid := func(message string) error {
+		err := errInvalidArgument
+		err.Message = message
+		return err
+	}
+	invalidRequest := func(message string) error {
+		err := errInvalidRequest
+		err.Message = message
+		return err
+	}
+
+	switch {
+	case len(rule.ID) > 255:
+		return invalid("ID length should not exceed allowed limit of 255")
+	case rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil:
+		return invalidRequest("At least one action needs to be specified in a rule")
+	case rule.NoncurrentVersionExpiration != nil && rule.NoncurrentVersionExpiration.NoncurrentDays <= 0:
+		return invalid("'NoncurrentDays' for NoncurrentVersionExpiration action must be a positive integer")
+	case rule.AbortIncompleteMultipartUpload != nil && rule.AbortIncompleteMultipartUpload.DaysAfterInitiation <= 0:
+		return invalid("'DaysAfterInitiation' for AbortIncompleteMultipartUpload action must be a positive integer")
+	case rule.AbortIncompleteMultipartUpload != nil && len(rule.tags()) != 0:
+		return invalidRequest("AbortIncompleteMultipartUpload cannot be specified with Tags.")
+	}
+
+	e := rule.Expiration
+	if e == nil {
+		return nil
+	}
+	set := 0
+	for _, ok := range []bool{e.Days != nil, e.Date != "", e.ExpiredObjectDeleteMarker != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return errMalformedXML
+	}
+	switch {
+	case e.Days != nil && *e.Days <= 0:
+		return invalid("'Days' for Expiration action must be a positive integer")
+	case e.Date != "":
+		date, err := time.Parse(time.RFC3339, e.Date)
+		if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+			return invalid("'Date' must be at midnight GMT")
+		}
+	case e.ExpiredObjectDeleteMarker != nil && len(rule.tags()) != 0:
+		return invalidRequest("ExpiredObjectDeleteMarker cannot be specified with Tags.")
+	}
+	return nil
+}
+
+// valid reports whether no more than one condition of the filter is set.  An
+// empty filter applies to every object.
+func (f lifecycleFilter) valid() bool {
+	set := 0
+	for _, ok := range []bool{f.Prefix != nil, f.Tag != nil, f.ObjectSizeGreaterThan != nil, f.ObjectSizeLessThan != nil, f.And != nil} {
+		if ok {
+			set++
+		}
+	}
+	return set <= 1
+}
+
+// prefix returns the key prefix the rule applies to.
+func (rule lifecycleRule) prefix() string {
+	switch {
+	case rule.Prefix != nil:
+		return *rule.Prefix
+	case rule.Filter == nil:
+		return ""
+	case rule.Filter.Prefix != nil:
+		return *rule.Filter.Prefix
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Prefix
+	}
+	return ""
+}
+
+// tags returns the tags an object must have for the rule to apply.
+func (rule lifecycleRule) tags() []tag {
+	switch {
+	case rule.Filter == nil:
+		return nil
+	case rule.Filter.Tag != nil:
+		return []tag{*rule.Filter.Tag}
+	case rule.Filter.And != nil:
+		return rule.Filter.And.Tags
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to an object.
+func (rule lifecycleRule) matches(key string, meta objectMeta, size int64) bool {
+	if !strings.HasPrefix(key, rule.prefix()) {
+		return false
+	}
+	for _, t := range rule.tags() {
+		if v, ok := meta.Tags[t.Key]; !ok || v != t.Value {
+			return false
+		}
+	}
+
+	if f := rule.Filter; f != nil {
+		greaterThan, lessThan := f.ObjectSizeGreaterThan, f.ObjectSizeLessThan
+		if f.And != nil {
+			greaterThan, lessThan = f.And.ObjectSizeGreaterThan, f.And.ObjectSizeLessThan
+		}
+		if greaterThan != nil && size <= *greaterThan {
+			return false
+		}
+		if lessThan != nil && size >= *lessThan {
+			return false
+		}
+	}
+	return true
+}
+
+// expired reports whether now is past the given number of days after t.
+// As with S3, the time is rounded up to the following midnight UTC.
+func expired(t time.Time, days int, now time.Time) bool {
+	at := t.UTC().AddDate(0, 0, days)
+	midnight := at.Truncate(24 * time.Hour)
+	if midnight.Before(at) {
+		midnight = midnight.Add(24 * time.Hour)
+	}
+	return !now.Before(midnight)
+}
+
+// now returns the time lifecycle rules are applied at.
+func (h Handler) now() time.Time {
+	if h.Clock != nil {
+		return h.Clock.Now()
+	}
+	return timeNow()
+}
+
+// RunLifecycle applies the lifecycle configuration of each bucket every
+// interval, until ctx is done.  Errors are logged.
+func (h Handler) RunLifecycle(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Sweep(); err != nil {
+				log.Printf("s3: applying lifecycle rules: %v", err)
+			}
+		}
+	}
+}
+
+// Sweep applies the lifecycle configuration of each bucket once, expiring
+// objects and aborting incomplete multipart uploads as of the time of the
+// Handler's Clock.
+func (h Handler) Sweep() error {
+	entries, err := os.ReadDir(h.Directory)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, e := range entries {
+		if !e.IsDir() || !validBucketName(e.Name()) {
+			continue
+		}
+		config, err := h.readLifecycleConfiguration(e.Name())
+		if err != nil {
+			if !isNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, rule := range config.Rules {
+			if rule.Status != "Enabled" {
+				continue
+			}
+			if err := h.applyLifecycleRule(e.Name(), rule, h.now()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyLifecycleRule performs the actions of rule which are due at now.
+func (h Handler) applyLifecycleRule(bucket string, rule lifecycleRule, now time.Time) error {
+	if e := rule.Expiration; e != nil && (e.Days != nil || e.Date != "") {
+		objects, err := h.walkObjects(bucket, rule.prefix())
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			meta, err := h.loadMeta(bucket, o.Key, o.Info)
+			if err != nil || !rule.matches(o.Key, meta, meta.dataSize(objectSize(o.Info))) {
+				continue
+			}
+			if e.Days != nil && !expired(o.Info.ModTime(), *e.Days, now) {
+				continue
+			}
+			if date, err := time.Parse(time.RFC3339, e.Date); e.Date != "" && (err != nil || now.Before(date)) {
+				continue
+			}
+			if _, err := h.deleteCurrentObject(bucket, o.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rule.NoncurrentVersionExpiration != nil || (rule.Expiration != nil && rule.Expiration.ExpiredObjectDeleteMarker != nil) {
+		if err := h.expireVersions(bucket, rule, now); err != nil {
+			return err
+		}
+	}
+
+	if a := rule.AbortIncompleteMultipartUpload; a != nil {
+		uploads, err := h.readUploads(bucket)
+		if err != nil {
+			return err
+		}
+		for _, u := range uploads {
+			if !strings.HasPrefix(u.Key, rule.prefix()) || !expired(u.Initiated, a.DaysAfterInitiation, now) {
+				continue
+			}
+			if err := os.RemoveAll(h.uploadPath(bucket, u.UploadID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expireVersions removes the noncurrent versions which have expired, and
+// delete markers which are the only remaining version of an object.
+func (h Handler) expireVersions(bucket string, rule lifecycleRule, now time.Time) error {
+	versions, err := h.walkVersions(bucket, rule.prefix())
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string][]objectVersion{}
+	for _, v := range versions {
+		byKey[v.Key] = append(byKey[v.Key], v)
+	}
+
+	for key, versions := range byKey {
+		// Versions are newest first, so the first is current, whether it
+		// is the object or a delete marker.
+		for _, v := range versions[1:] {
+			if !rule.matches(key, v.Meta, v.Meta.Size) {
+				continue
+			}
+			n := rule.NoncurrentVersionExpiration
+			if n == nil || !expired(v.Archived, n.NoncurrentDays, now) {
+				continue
+			}
+			if _, err := h.deleteObjectVersion(bucket, key, v.VersionID); err != nil {
+				return err
+			}
+		}
+
+		if e := rule.Expiration; e == nil || e.ExpiredObjectDeleteMarker == nil || !*e.ExpiredObjectDeleteMarker {
+			continue
+		}
+		if _, err := h.statObject(bucket, key); err == nil {
+			continue
+		}
+		remaining, err := h.readVersions(bucket, key)
+		if err != nil {
+			return err
+		}
+		if len(remaining) != 1 || !remaining[0].DeleteMarker {
+			continue
+		}
+		if _, err := h.deleteObjectVersion(bucket, key, remaining[0].VersionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
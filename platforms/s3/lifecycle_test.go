@@ -0,0 +1,323 @@
+package s3
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const expirationLifecycle = `<LifecycleConfiguration>
+	<Rule>
+		<ID>logs</ID>
+		<Status>Enabled</Status>
+		<Filter><Prefix>logs/</Prefix></Filter>
+		<Expiration><Days>1</Days></Expiration>
+	</Rule>
+	<Rule>
+		<ID>temporary</ID>
+		<Status>Enabled</Status>
+		<Filter><And><Prefix>data/</Prefix><Tag><Key>temporary</Key><Value>true</Value></Tag></And></Filter>
+		<Expiration><Days>3</Days></Expiration>
+	</Rule>
+	<Rule>
+		<ID>disabled</ID>
+		<Status>Disabled</Status>
+		<Filter></Filter>
+		<Expiration><Days>1</Days></Expiration>
+	</Rule>
+</LifecycleConfiguration>`
+
+func TestBucketLifecycle(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodGet, "/bucket?lifecycle", "")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), "<Code>NoSuchLifecycleConfiguration</Code>") {
+		t.Errorf("expected NoSuchLifecycleConfiguration got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket?lifecycle", expirationLifecycle)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket?lifecycle", "")
+	for _, want := range []string{
+		"<ID>logs</ID>",
+		"<Filter><Prefix>logs/</Prefix></Filter>",
+		"<Expiration><Days>1</Days></Expiration>",
+		"<Tag><Key>temporary</Key><Value>true</Value></Tag>",
+	} {
+		if !strings.Contains(res.Body.String(), want) {
+			t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+		}
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket?lifecycle", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected code to be %d got %d", http.StatusNoContent, res.Code)
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket?lifecycle", ""); res.Code != http.StatusNotFound {
+		t.Errorf("expected code to be %d got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestPutBucketLifecycleInvalid(t *testing.T) {
+	rule := func(s string) string {
+		return "<LifecycleConfiguration><Rule>" + s + "</Rule></LifecycleConfiguration>"
+	}
+
+	tests := map[string]struct {
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		"no rules":            {body: "<LifecycleConfiguration></LifecycleConfiguration>", wantCode: http.StatusBadRequest, wantBody: "<Code>MalformedXML</Code>"},
+		"status":              {body: rule("<Status>On</Status><Expiration><Days>1</Days></Expiration>"), wantCode: http.StatusBadRequest, wantBody: "<Code>MalformedXML</Code>"},
+		"no action":           {body: rule("<Status>Enabled</Status>"), wantCode: http.StatusBadRequest, wantBody: "<Message>At least one action needs to be specified in a rule</Message>"},
+		"days":                {body: rule("<Status>Enabled</Status><Expiration><Days>0</Days></Expiration>"), wantCode: http.StatusBadRequest, wantBody: "<Message>&#39;Days&#39; for Expiration action must be a positive integer</Message>"},
+		"date":                {body: rule("<Status>Enabled</Status><Expiration><Date>2024-01-01T12:00:00Z</Date></Expiration>"), wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"days and date":       {body: rule("<Status>Enabled</Status><Expiration><Days>1</Days><Date>2024-01-01T00:00:00Z</Date></Expiration>"), wantCode: http.StatusBadRequest, wantBody: "<Code>MalformedXML</Code>"},
+		"two filters":         {body: rule("<Status>Enabled</Status><Filter><Prefix>a</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Expiration><Days>1</Days></Expiration>"), wantCode: http.StatusBadRequest, wantBody: "<Code>MalformedXML</Code>"},
+		"abort with tags":     {body: rule("<Status>Enabled</Status><Filter><Tag><Key>k</Key><Value>v</Value></Tag></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>"), wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidRequest</Code>"},
+		"noncurrent days":     {body: rule("<Status>Enabled</Status><NoncurrentVersionExpiration><NoncurrentDays>-1</NoncurrentDays></NoncurrentVersionExpiration>"), wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"duplicate id":        {body: "<LifecycleConfiguration><Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule><Rule><ID>a</ID><Status>Enabled</Status><Expiration><Days>2</Days></Expiration></Rule></LifecycleConfiguration>", wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"deprecated prefix":   {body: rule("<Prefix>logs/</Prefix><Status>Enabled</Status><Expiration><Days>1</Days></Expiration>"), wantCode: http.StatusOK},
+		"midnight date":       {body: rule("<Status>Enabled</Status><Expiration><Date>2024-01-01T00:00:00.000Z</Date></Expiration>"), wantCode: http.StatusOK},
+		"delete marker alone": {body: rule("<Status>Enabled</Status><Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration>"), wantCode: http.StatusOK},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := doRequest(h, http.MethodPut, "/bucket?lifecycle", tc.body)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestLifecycleExpiration(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.Clock = &Clock{}
+
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", expirationLifecycle)
+	doRequest(h, http.MethodPut, "/bucket/logs/a.log", "a")
+	doRequest(h, http.MethodPut, "/bucket/data/b", "b", "X-Amz-Tagging", "temporary=true")
+	doRequest(h, http.MethodPut, "/bucket/data/c", "c")
+
+	steps := []struct {
+		advance time.Duration
+		want    []string
+	}{
+		{advance: 0, want: []string{"data/b", "data/c", "logs/a.log"}},
+		{advance: 48 * time.Hour, want: []string{"data/b", "data/c"}},
+		{advance: 48 * time.Hour, want: []string{"data/c"}},
+	}
+
+	for i, step := range steps {
+		h.Clock.Advance(step.advance)
+		if err := h.Sweep(); err != nil {
+			t.Fatalf("step %d: expected err to be nil got %v", i, err)
+		}
+		_, keys, _ := listKeys(t, h, "/bucket?list-type=2")
+		if diff := cmp.Diff(step.want, keys); diff != "" {
+			t.Errorf("step %d: unexpected keys (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestLifecycleVersions(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.Clock = &Clock{}
+
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", `<LifecycleConfiguration>
+		<Rule>
+			<ID>noncurrent</ID>
+			<Status>Enabled</Status>
+			<Filter></Filter>
+			<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration>
+		</Rule>
+		<Rule>
+			<ID>markers</ID>
+			<Status>Enabled</Status>
+			<Filter></Filter>
+			<Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration>
+		</Rule>
+	</LifecycleConfiguration>`)
+
+	doRequest(h, http.MethodPut, "/bucket/deleted", "1")
+	doRequest(h, http.MethodPut, "/bucket/deleted", "2")
+	doRequest(h, http.MethodDelete, "/bucket/deleted", "")
+	doRequest(h, http.MethodPut, "/bucket/kept", "1")
+	res := doRequest(h, http.MethodPut, "/bucket/kept", "2")
+	current := res.Header().Get("X-Amz-Version-Id")
+
+	if err := h.Sweep(); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if _, lines := listVersions(t, h, "/bucket?versions"); len(lines) != 5 {
+		t.Errorf("expected 5 versions before they expire got %v", lines)
+	}
+
+	h.Clock.Advance(48 * time.Hour)
+	if err := h.Sweep(); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	want := []string{"Version kept " + current + " latest"}
+	if _, lines := listVersions(t, h, "/bucket?versions"); !cmp.Equal(want, lines) {
+		t.Errorf("expected versions to be %v got %v", want, lines)
+	}
+}
+
+func TestLifecycleAbortIncompleteMultipartUpload(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.Clock = &Clock{}
+
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", `<LifecycleConfiguration>
+		<Rule>
+			<Status>Enabled</Status>
+			<Filter><Prefix>tmp/</Prefix></Filter>
+			<AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+		</Rule>
+	</LifecycleConfiguration>`)
+	aborted := createUpload(t, h, "/bucket/tmp/large.bin")
+	kept := createUpload(t, h, "/bucket/other/large.bin")
+
+	h.Clock.Advance(48 * time.Hour)
+	if err := h.Sweep(); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	if res := doRequest(h, http.MethodGet, "/bucket/tmp/large.bin?uploadId="+aborted, ""); res.Code != http.StatusNotFound {
+		t.Errorf("expected aborted upload status to be %d got %d", http.StatusNotFound, res.Code)
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket/other/large.bin?uploadId="+kept, ""); res.Code != http.StatusOK {
+		t.Errorf("expected kept upload status to be %d got %d", http.StatusOK, res.Code)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	created := time.Date(2024, 1, 1, 15, 30, 0, 0, time.UTC)
+	tests := map[string]struct {
+		now  time.Time
+		days int
+		want bool
+	}{
+		"same day":        {now: time.Date(2024, 1, 2, 15, 30, 0, 0, time.UTC), days: 1, want: false},
+		"next midnight":   {now: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), days: 1, want: true},
+		"before midnight": {now: time.Date(2024, 1, 3, 23, 59, 59, 0, time.UTC), days: 2, want: false},
+		"days later":      {now: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), days: 30, want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := expired(created, tc.days, tc.now); got != tc.want {
+				t.Errorf("expected expired to be %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLifecycleCurrentDeleteMarker(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.Clock = &Clock{}
+
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", `<LifecycleConfiguration>
+		<Rule>
+			<Status>Enabled</Status>
+			<Filter></Filter>
+			<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration>
+		</Rule>
+	</LifecycleConfiguration>`)
+
+	doRequest(h, http.MethodPut, "/bucket/key", "1")
+	doRequest(h, http.MethodPut, "/bucket/key", "2")
+	res := doRequest(h, http.MethodDelete, "/bucket/key", "")
+	marker := res.Header().Get("X-Amz-Version-Id")
+
+	h.Clock.Advance(48 * time.Hour)
+	if err := h.Sweep(); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	// The delete marker is current, so it is kept while the versions it
+	// hides expire.
+	want := []string{"DeleteMarker key " + marker + " latest"}
+	if _, lines := listVersions(t, h, "/bucket?versions"); !cmp.Equal(want, lines) {
+		t.Errorf("expected versions to be %v got %v", want, lines)
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket/key", ""); res.Code != http.StatusNotFound {
+		t.Errorf("expected get status to be %d got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestClockEndpoint(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", expirationLifecycle)
+	doRequest(h, http.MethodPut, "/bucket/logs/a.log", "a")
+
+	if res := doRequest(h, http.MethodGet, "/-/clock", ""); res.Code != http.StatusBadRequest {
+		t.Errorf("expected status without a clock to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+
+	h.Clock = &Clock{}
+	now := func(res *httptest.ResponseRecorder) time.Time {
+		t.Helper()
+		status := clockStatus{}
+		if err := json.Unmarshal(res.Body.Bytes(), &status); err != nil {
+			t.Fatalf("expected err to be nil got %v: %s", err, res.Body.String())
+		}
+		parsed, err := time.Parse(time.RFC3339, status.Now)
+		if err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+		return parsed
+	}
+
+	before := now(doRequest(h, http.MethodGet, "/-/clock", ""))
+	res := doRequest(h, http.MethodPost, "/-/clock?advance=48h", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if d := now(res).Sub(before); d < 48*time.Hour || d > 49*time.Hour {
+		t.Errorf("expected clock to advance 48h got %v", d)
+	}
+
+	// Advancing the clock applies lifecycle rules.
+	if _, keys, _ := listKeys(t, h, "/bucket?list-type=2"); len(keys) != 0 {
+		t.Errorf("expected logs/a.log to expire got %v", keys)
+	}
+
+	tests := map[string]struct {
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		"negative": {method: http.MethodPost, target: "/-/clock?advance=-1h", wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"missing":  {method: http.MethodPost, target: "/-/clock", wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"method":   {method: http.MethodPut, target: "/-/clock", wantCode: http.StatusMethodNotAllowed, wantBody: "<Code>MethodNotAllowed</Code>"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, tc.method, tc.target, "")
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultMaxKeys = 1000
+
+// ownerID is the canonical user ID reported as the owner of every bucket
+// and object.
+const ownerID = "75aa57f09aa0c8caeab4f8c24e99d10f8e7faeebf76c078efc7c6caea54ba06a"
+const ownerDisplayName = "hs"
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"ListAllMyBucketsResult"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []listContents `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	Owner        *owner `xml:"Owner"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// objectEntry is an object found while walking a bucket.
+type objectEntry struct {
+	Key  string
+	Info os.FileInfo
+}
+
+// listParams are the options shared by both versions of ListObjects.
+type listParams struct {
+	Prefix       string
+	Delimiter    string
+	Marker       string
+	MaxKeys      int
+	EncodingType string
+}
+
+// listPage is a single page of results from listing a bucket.
+type listPage struct {
+	Objects        []objectEntry
+	CommonPrefixes []string
+	IsTruncated    bool
+	// Last is the last key or common prefix included in the page.
+	Last string
+}
+
+func (h Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	entries, err := os.ReadDir(h.Directory)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := listAllMyBucketsResult{
+		Xmlns:   xmlns,
+		Owner:   owner{ID: ownerID, DisplayName: ownerDisplayName},
+		Buckets: []bucketInfo{},
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !validBucketName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketInfo{
+			Name:         e.Name(),
+			CreationDate: formatISO8601(info.ModTime()),
+		})
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (h Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	params, ok := parseListParams(query)
+	if !ok {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	params.Marker = query.Get("marker")
+
+	page, err := h.listPage(bucket, params)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := h.newListBucketResult(bucket, params, page, true)
+	marker := encodeKey(params.Marker, params.EncodingType)
+	result.Marker = &marker
+	if page.IsTruncated && params.Delimiter != "" {
+		result.NextMarker = encodeKey(page.Last, params.EncodingType)
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (h Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	params, ok := parseListParams(query)
+	if !ok {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	startAfter := query.Get("start-after")
+	params.Marker = startAfter
+	token := query.Get("continuation-token")
+	if query.Has("continuation-token") {
+		marker, err := decodeContinuationToken(token)
+		if err != nil {
+			writeError(w, r, errInvalidContinuationToken)
+			return
+		}
+		if marker > params.Marker {
+			params.Marker = marker
+		}
+	}
+
+	page, err := h.listPage(bucket, params)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := h.newListBucketResult(bucket, params, page, query.Get("fetch-owner") == "true")
+	keyCount := len(result.Contents) + len(result.CommonPrefixes)
+	result.KeyCount = &keyCount
+	result.ContinuationToken = token
+	result.StartAfter = encodeKey(startAfter, params.EncodingType)
+	if page.IsTruncated {
+		result.NextContinuationToken = encodeContinuationToken(page.Last)
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (h Handler) newListBucketResult(bucket string, params listParams, page listPage, withOwner bool) listBucketResult {
+	result := listBucketResult{
+		Xmlns:        xmlns,
+		Name:         bucket,
+		Prefix:       encodeKey(params.Prefix, params.EncodingType),
+		MaxKeys:      params.MaxKeys,
+		Delimiter:    encodeKey(params.Delimiter, params.EncodingType),
+		IsTruncated:  page.IsTruncated,
+		EncodingType: params.EncodingType,
+	}
+
+	for _, o := range page.Objects {
+		meta, err := h.loadMeta(bucket, o.Key, o.Info)
+		if err != nil {
+			continue
+		}
+		c := listContents{
+			Key:          encodeKey(o.Key, params.EncodingType),
+			LastModified: formatISO8601(o.Info.ModTime()),
+			ETag:         quoteETag(meta.ETag),
+			Size:         meta.dataSize(objectSize(o.Info)),
+			StorageClass: "STANDARD",
+		}
+		if withOwner {
+			c.Owner = &owner{ID: ownerID, DisplayName: ownerDisplayName}
+		}
+		result.Contents = append(result.Contents, c)
+	}
+
+	for _, p := range page.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encodeKey(p, params.EncodingType)})
+	}
+
+	return result
+}
+
+func parseListParams(query url.Values) (listParams, bool) {
+	params := listParams{
+		Prefix:       query.Get("prefix"),
+		Delimiter:    query.Get("delimiter"),
+		EncodingType: query.Get("encoding-type"),
+	}
+
+	if params.EncodingType != "" && params.EncodingType != "url" {
+		return params, false
+	}
+
+	maxKeys, ok := parseMaxParam(query, "max-keys", defaultMaxKeys)
+	params.MaxKeys = maxKeys
+	return params, ok
+}
+
+// listPage returns the objects and common prefixes which sort after the
+// marker in UTF-8 binary order, grouping keys by delimiter when one is
+// given.
+func (h Handler) listPage(bucket string, params listParams) (listPage, error) {
+	page := listPage{}
+
+	objects, err := h.walkObjects(bucket, params.Prefix)
+	if err != nil {
+		return page, err
+	}
+
+	skipPrefix := ""
+	if params.Marker != "" && commonPrefixOf(params.Marker, params.Prefix, params.Delimiter) == params.Marker {
+		skipPrefix = params.Marker
+	}
+
+	count := 0
+	for _, o := range objects {
+		if o.Key <= params.Marker || (skipPrefix != "" && strings.HasPrefix(o.Key, skipPrefix)) {
+			continue
+		}
+
+		cp := commonPrefixOf(o.Key, params.Prefix, params.Delimiter)
+		if cp != "" && cp == page.Last {
+			continue
+		}
+
+		if count == params.MaxKeys {
+			page.IsTruncated = true
+			break
+		}
+		count++
+
+		if cp != "" {
+			page.CommonPrefixes = append(page.CommonPrefixes, cp)
+			page.Last = cp
+			continue
+		}
+		page.Objects = append(page.Objects, o)
+		page.Last = o.Key
+	}
+
+	return page, nil
+}
+
+// commonPrefixOf returns the common prefix key rolls up into, or an empty
+// string if it is not grouped.
+func commonPrefixOf(key, prefix, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(key, prefix) {
+		return ""
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(prefix)+i+len(delimiter)]
+}
+
+// walkObjects returns every object in bucket beginning with prefix, sorted
+// by key.
+func (h Handler) walkObjects(bucket, prefix string) ([]objectEntry, error) {
+	root := h.bucketPath(bucket)
+	start := root
+	base := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 && validKey(prefix[:i+1]) == nil {
+		base = prefix[:i+1]
+		start = filepath.Join(root, filepath.FromSlash(base))
+	}
+
+	objects := []objectEntry{}
+	_, err := walkDir(start, base, func(key string, info os.FileInfo) {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, objectEntry{Key: key, Info: info})
+		}
+	})
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// walkDir calls fn for each regular file below dir, and for each empty
+// directory which is reported as a folder key.  The number of entries in
+// dir is returned.
+func walkDir(dir, base string, fn func(key string, info os.FileInfo)) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+
+		if !info.IsDir() {
+			if info.Mode().IsRegular() {
+				fn(base+e.Name(), info)
+			}
+			continue
+		}
+
+		n, err := walkDir(filepath.Join(dir, e.Name()), base+e.Name()+"/", fn)
+		if err == nil && n == 0 {
+			fn(base+e.Name()+"/", info)
+		}
+	}
+
+	return len(entries), nil
+}
+
+func encodeContinuationToken(marker string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(marker))
+}
+
+func decodeContinuationToken(token string) (string, error) {
+	marker, err := base64.RawURLEncoding.DecodeString(token)
+	return string(marker), err
+}
+
+// encodeKey applies the requested encoding-type to a value returned in a
+// listing.
+func encodeKey(key, encodingType string) string {
+	if encodingType != "url" {
+		return key
+	}
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
+
+func objectSize(info os.FileInfo) int64 {
+	if info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
+
+func formatISO8601(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
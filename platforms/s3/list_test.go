@@ -0,0 +1,191 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var listTestKeys = []string{
+	"a-c",
+	"a/b",
+	"a/c/d",
+	"b",
+	"photos/2024/jan.jpg",
+	"photos/2024/feb.jpg",
+	"photos/2025/mar.jpg",
+	"photos/empty/",
+	"z with space",
+}
+
+func newListTestHandler(t *testing.T) Handler {
+	t.Helper()
+	h := newTestHandler(t, "bucket")
+	for _, k := range listTestKeys {
+		res := doRequest(h, http.MethodPut, "/bucket/"+url.PathEscape(k), "")
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected put status to be %v got %v", http.StatusOK, res.Code)
+		}
+	}
+	return h
+}
+
+func listKeys(t *testing.T, h Handler, target string) (listBucketResult, []string, []string) {
+	t.Helper()
+	res := doRequest(h, http.MethodGet, target, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected list status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	result := listBucketResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	keys := []string{}
+	for _, c := range result.Contents {
+		keys = append(keys, c.Key)
+	}
+	prefixes := []string{}
+	for _, p := range result.CommonPrefixes {
+		prefixes = append(prefixes, p.Prefix)
+	}
+	return result, keys, prefixes
+}
+
+func TestListObjectsV2(t *testing.T) {
+	tests := map[string]struct {
+		query        string
+		wantKeys     []string
+		wantPrefixes []string
+		wantTrunc    bool
+	}{
+		"all keys in binary order": {
+			query:        "list-type=2",
+			wantKeys:     []string{"a-c", "a/b", "a/c/d", "b", "photos/2024/feb.jpg", "photos/2024/jan.jpg", "photos/2025/mar.jpg", "photos/empty/", "z with space"},
+			wantPrefixes: []string{},
+		},
+		"delimiter": {
+			query:        "list-type=2&delimiter=/",
+			wantKeys:     []string{"a-c", "b", "z with space"},
+			wantPrefixes: []string{"a/", "photos/"},
+		},
+		"prefix and delimiter": {
+			query:        "list-type=2&prefix=photos/&delimiter=/",
+			wantKeys:     []string{},
+			wantPrefixes: []string{"photos/2024/", "photos/2025/", "photos/empty/"},
+		},
+		"partial prefix": {
+			query:        "list-type=2&prefix=photos/202",
+			wantKeys:     []string{"photos/2024/feb.jpg", "photos/2024/jan.jpg", "photos/2025/mar.jpg"},
+			wantPrefixes: []string{},
+		},
+		"start after": {
+			query:        "list-type=2&start-after=photos/2024/jan.jpg",
+			wantKeys:     []string{"photos/2025/mar.jpg", "photos/empty/", "z with space"},
+			wantPrefixes: []string{},
+		},
+		"max keys": {
+			query:        "list-type=2&max-keys=2",
+			wantKeys:     []string{"a-c", "a/b"},
+			wantPrefixes: []string{},
+			wantTrunc:    true,
+		},
+		"url encoding": {
+			query:        "list-type=2&prefix=z&encoding-type=url",
+			wantKeys:     []string{"z+with+space"},
+			wantPrefixes: []string{},
+		},
+	}
+
+	h := newListTestHandler(t)
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			result, keys, prefixes := listKeys(t, h, "/bucket?"+tc.query)
+			if diff := cmp.Diff(tc.wantKeys, keys); diff != "" {
+				t.Errorf("keys: %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantPrefixes, prefixes); diff != "" {
+				t.Errorf("prefixes: %s", diff)
+			}
+			if result.IsTruncated != tc.wantTrunc {
+				t.Errorf("expected truncated to be %v got %v", tc.wantTrunc, result.IsTruncated)
+			}
+			if result.KeyCount == nil || *result.KeyCount != len(keys)+len(prefixes) {
+				t.Errorf("expected key count to be %v got %v", len(keys)+len(prefixes), result.KeyCount)
+			}
+		})
+	}
+}
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	h := newListTestHandler(t)
+
+	keys := []string{}
+	prefixes := []string{}
+	target := "/bucket?list-type=2&delimiter=/&max-keys=1"
+	for i := 0; i < 10; i++ {
+		result, k, p := listKeys(t, h, target)
+		keys = append(keys, k...)
+		prefixes = append(prefixes, p...)
+		if !result.IsTruncated {
+			break
+		}
+		target = "/bucket?list-type=2&delimiter=/&max-keys=1&continuation-token=" + result.NextContinuationToken
+	}
+
+	if diff := cmp.Diff([]string{"a-c", "b", "z with space"}, keys); diff != "" {
+		t.Errorf("keys: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"a/", "photos/"}, prefixes); diff != "" {
+		t.Errorf("prefixes: %s", diff)
+	}
+}
+
+func TestListObjectsV1(t *testing.T) {
+	h := newListTestHandler(t)
+
+	result, keys, prefixes := listKeys(t, h, "/bucket?delimiter=/&max-keys=2")
+	if diff := cmp.Diff([]string{"a-c"}, keys); diff != "" {
+		t.Errorf("keys: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"a/"}, prefixes); diff != "" {
+		t.Errorf("prefixes: %s", diff)
+	}
+	if !result.IsTruncated || result.NextMarker != "a/" {
+		t.Fatalf("expected next marker to be %v got %v", "a/", result.NextMarker)
+	}
+
+	_, keys, prefixes = listKeys(t, h, "/bucket?delimiter=/&marker=a/")
+	if diff := cmp.Diff([]string{"b", "z with space"}, keys); diff != "" {
+		t.Errorf("keys: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"photos/"}, prefixes); diff != "" {
+		t.Errorf("prefixes: %s", diff)
+	}
+}
+
+func TestListBuckets(t *testing.T) {
+	h := newTestHandler(t, "one", "two")
+
+	res := doRequest(h, http.MethodGet, "/", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	result := listAllMyBucketsResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	names := []string{}
+	for _, b := range result.Buckets {
+		names = append(names, b.Name)
+	}
+	if diff := cmp.Diff([]string{"one", "two"}, names); diff != "" {
+		t.Errorf("buckets: %s", diff)
+	}
+}
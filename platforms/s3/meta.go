@@ -0,0 +1,215 @@
+package s3
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectMeta is the state kept for an object which can not be derived from
+// the stored file itself.  Size and ModTime record the file the metadata was
+// written for, so changes made directly on disk invalidate it.
+type objectMeta struct {
+	ETag    string    `json:"etag"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// VersionID is empty for objects stored while the bucket was
+	// unversioned.
+	VersionID string `json:"versionId,omitempty"`
+
+	// Headers are the system headers, from storedHeaders, the object was
+	// uploaded with.
+	Headers map[string]string `json:"headers,omitempty"`
+	// UserMetadata are the x-amz-meta-* headers the object was uploaded
+	// with, keyed by the lower case name without the prefix.
+	UserMetadata map[string]string `json:"userMetadata,omitempty"`
+	// Tags are the object's tags, set with the x-amz-tagging header or the
+	// PutObjectTagging API.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Checksum is the base64 encoded x-amz-checksum-* value of the object,
+	// computed with ChecksumAlgorithm.  ChecksumType is COMPOSITE or
+	// FULL_OBJECT for objects uploaded in parts, and empty otherwise.
+	ChecksumAlgorithm string `json:"checksumAlgorithm,omitempty"`
+	Checksum          string `json:"checksum,omitempty"`
+	ChecksumType      string `json:"checksumType,omitempty"`
+
+	// Encryption is set for objects encrypted at rest.  dataKey is the
+	// decrypted key, which is only set while the object is being stored.
+	Encryption *objectEncryption `json:"encryption,omitempty"`
+	dataKey    []byte
+}
+
+// storedHeaders are the system headers persisted with an object and
+// returned when it is read.
+var storedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+}
+
+const userMetadataPrefix = "X-Amz-Meta-"
+const maxUserMetadataSize = 2 * 1024
+
+// newObjectMeta returns the metadata to store for an object uploaded with
+// header.
+func newObjectMeta(header http.Header) (objectMeta, error) {
+	meta := objectMeta{
+		Headers:      map[string]string{},
+		UserMetadata: map[string]string{},
+	}
+
+	for _, name := range storedHeaders {
+		if v := header.Get(name); v != "" {
+			meta.Headers[name] = v
+		}
+	}
+
+	size := 0
+	for name, values := range header {
+		if !strings.HasPrefix(name, userMetadataPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, userMetadataPrefix))
+		value := strings.Join(values, ",")
+		meta.UserMetadata[key] = value
+		size += len(key) + len(value)
+	}
+	if size > maxUserMetadataSize {
+		return meta, errMetadataTooLarge
+	}
+
+	tags, err := parseTaggingHeader(header.Get("X-Amz-Tagging"))
+	if err != nil {
+		return meta, err
+	}
+	meta.Tags = tags
+
+	return meta, nil
+}
+
+// writeHeaders sets the stored headers and user metadata on header.
+func (m objectMeta) writeHeaders(header http.Header) {
+	for name, value := range m.Headers {
+		header.Set(name, value)
+	}
+	for key, value := range m.UserMetadata {
+		header.Set(userMetadataPrefix+key, value)
+	}
+}
+
+// dataSize returns the size of the object's data, given the size of the
+// file holding it, which is larger when the object is encrypted.
+func (m objectMeta) dataSize(size int64) int64 {
+	if m.Encryption != nil {
+		return decryptedSize(size)
+	}
+	return size
+}
+
+// versionID returns the ID used to address the object's version, which is
+// "null" for objects stored while the bucket was unversioned.
+func (m objectMeta) versionID() string {
+	if m.VersionID == "" {
+		return nullVersionID
+	}
+	return m.VersionID
+}
+
+func (h Handler) metaPath(bucket, key string) string {
+	return h.systemPath(bucket, "meta", filepath.FromSlash(key)+".json")
+}
+
+// loadMeta returns the metadata of an object, falling back to what can be
+// derived from the file itself when nothing valid has been stored.
+func (h Handler) loadMeta(bucket, key string, info os.FileInfo) (objectMeta, error) {
+	if info.IsDir() {
+		// Only the tags of folder objects are stored, the size and
+		// modification time of directories are not stable.
+		stored := objectMeta{}
+		readJSON(h.metaPath(bucket, key), &stored)
+		return objectMeta{ETag: emptyMD5, ModTime: info.ModTime(), Tags: stored.Tags}, nil
+	}
+
+	if meta, ok := h.readMeta(bucket, key, info); ok && meta.ETag != "" {
+		return meta, nil
+	}
+
+	etag, err := fileMD5(h.objectPath(bucket, key))
+	if err != nil {
+		return objectMeta{}, err
+	}
+	return objectMeta{ETag: etag, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// readMeta returns the metadata stored for an object, if it is still valid
+// for the file described by info.
+func (h Handler) readMeta(bucket, key string, info os.FileInfo) (objectMeta, bool) {
+	meta := objectMeta{}
+	if err := readJSON(h.metaPath(bucket, key), &meta); err != nil {
+		return meta, false
+	}
+	if meta.Size != info.Size() || !meta.ModTime.Equal(info.ModTime()) {
+		return meta, false
+	}
+	return meta, true
+}
+
+// writeMeta stores the metadata for an object, which must already exist.
+func (h Handler) writeMeta(bucket, key string, meta objectMeta) error {
+	info, err := os.Stat(h.objectPath(bucket, key))
+	if err != nil {
+		return err
+	}
+	meta.Size = info.Size()
+	meta.ModTime = info.ModTime()
+	return h.writeJSON(h.metaPath(bucket, key), meta)
+}
+
+func (h Handler) removeMeta(bucket, key string) {
+	target := h.metaPath(bucket, key)
+	os.Remove(target)
+	removeEmptyParents(h.systemPath(bucket, "meta"), filepath.Dir(target))
+}
+
+func readJSON(name string, v any) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON atomically replaces the contents of name with v encoded as JSON.
+func (h Handler) writeJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := h.createTemp()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,722 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxPartNumber = 10000
+const minPartSize = 5 * 1024 * 1024
+const maxPartSize = 5 * 1024 * 1024 * 1024
+const defaultMaxParts = 1000
+const defaultMaxUploads = 1000
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// upload is the state stored for an in progress multipart upload.
+type upload struct {
+	Key       string    `json:"key"`
+	UploadID  string    `json:"uploadId"`
+	Initiated time.Time `json:"initiated"`
+	// Meta is stored with the object when the upload is completed.
+	Meta objectMeta `json:"meta"`
+	// DataKey is the data key of an encrypted upload, sealed with the master
+	// key so the upload can be completed without the customer's key.
+	DataKey string `json:"dataKey,omitempty"`
+}
+
+// part is the state stored for each uploaded part of a multipart upload.
+type part struct {
+	PartNumber   int       `json:"partNumber"`
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	// Checksum is the base64 encoded checksum of the part, computed with
+	// the checksum algorithm of the upload.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name   `xml:"ListPartsResult"`
+	Xmlns                string     `xml:"xmlns,attr"`
+	Bucket               string     `xml:"Bucket"`
+	Key                  string     `xml:"Key"`
+	UploadID             string     `xml:"UploadId"`
+	Initiator            owner      `xml:"Initiator"`
+	Owner                owner      `xml:"Owner"`
+	StorageClass         string     `xml:"StorageClass"`
+	PartNumberMarker     int        `xml:"PartNumberMarker"`
+	NextPartNumberMarker int        `xml:"NextPartNumberMarker"`
+	MaxParts             int        `xml:"MaxParts"`
+	IsTruncated          bool       `xml:"IsTruncated"`
+	Parts                []partInfo `xml:"Part"`
+}
+
+type partInfo struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type listMultipartUploadsResult struct {
+	XMLName            xml.Name       `xml:"ListMultipartUploadsResult"`
+	Xmlns              string         `xml:"xmlns,attr"`
+	Bucket             string         `xml:"Bucket"`
+	KeyMarker          string         `xml:"KeyMarker"`
+	UploadIDMarker     string         `xml:"UploadIdMarker"`
+	NextKeyMarker      string         `xml:"NextKeyMarker"`
+	NextUploadIDMarker string         `xml:"NextUploadIdMarker"`
+	Prefix             string         `xml:"Prefix"`
+	Delimiter          string         `xml:"Delimiter,omitempty"`
+	MaxUploads         int            `xml:"MaxUploads"`
+	IsTruncated        bool           `xml:"IsTruncated"`
+	Uploads            []uploadInfo   `xml:"Upload"`
+	CommonPrefixes     []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type uploadInfo struct {
+	Key          string `xml:"Key"`
+	UploadID     string `xml:"UploadId"`
+	Initiator    owner  `xml:"Initiator"`
+	Owner        owner  `xml:"Owner"`
+	StorageClass string `xml:"StorageClass"`
+	Initiated    string `xml:"Initiated"`
+}
+
+func (h Handler) uploadPath(bucket string, elem ...string) string {
+	return h.systemPath(append([]string{bucket, "uploads"}, elem...)...)
+}
+
+func (h Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	io.Copy(io.Discard, r.Body)
+
+	meta, err := newObjectMeta(r.Header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if meta.ChecksumAlgorithm, meta.ChecksumType, err = uploadChecksum(r.Header); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	meta.Encryption, meta.dataKey, err = h.newEncryption(r.Header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	u := upload{
+		Key:       key,
+		UploadID:  strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Initiated: timeNow().UTC(),
+		Meta:      meta,
+	}
+	if meta.dataKey != nil {
+		master, err := h.masterKey()
+		if err == nil {
+			u.DataKey, err = sealKey(master, meta.dataKey)
+		}
+		if err != nil {
+			writeError(w, r, errInternalError)
+			return
+		}
+	}
+	if err := h.writeJSON(h.uploadPath(bucket, u.UploadID, "upload.json"), u); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	if meta.ChecksumAlgorithm != "" {
+		w.Header().Set("X-Amz-Checksum-Algorithm", meta.ChecksumAlgorithm)
+		w.Header().Set("X-Amz-Checksum-Type", meta.ChecksumType)
+	}
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    xmlns,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: u.UploadID,
+	})
+}
+
+// uploadChecksum returns the checksum algorithm and type of a multipart
+// upload, from the x-amz-checksum-algorithm and x-amz-checksum-type headers.
+// Only CRC checksums can be combined into a FULL_OBJECT checksum.
+func uploadChecksum(header http.Header) (string, string, error) {
+	name := header.Get("X-Amz-Checksum-Algorithm")
+	checksumType := strings.ToUpper(header.Get("X-Amz-Checksum-Type"))
+	if name == "" {
+		if checksumType != "" {
+			return "", "", errInvalidChecksumType
+		}
+		return "", "", nil
+	}
+
+	alg, ok := findChecksumAlgorithm(name)
+	if !ok {
+		return "", "", errUnsupportedChecksumAlgorithm
+	}
+	switch checksumType {
+	case "":
+		checksumType = "COMPOSITE"
+		if alg.Name == "CRC64NVME" {
+			checksumType = "FULL_OBJECT"
+		}
+	case "COMPOSITE":
+		if alg.Name == "CRC64NVME" {
+			return "", "", errInvalidChecksumType
+		}
+	case "FULL_OBJECT":
+		if !strings.HasPrefix(alg.Name, "CRC") {
+			return "", "", errInvalidChecksumType
+		}
+	default:
+		return "", "", errInvalidChecksumType
+	}
+	return alg.Name, checksumType, nil
+}
+
+func (h Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	query := r.URL.Query()
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeError(w, r, errInvalidPartNumber)
+		return
+	}
+	if payloadLength(r) > maxPartSize {
+		writeError(w, r, errEntityTooLarge)
+		return
+	}
+
+	u, err := h.readUpload(bucket, key, query.Get("uploadId"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	dataKey, err := h.uploadDataKey(u, r.Header)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	body, err := newChecksumReader(r.Body, r.Header, u.Meta.ChecksumAlgorithm)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	p, err := h.storePart(bucket, u.UploadID, partNumber, body, dataKey)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(p.ETag))
+	writeChecksumHeaders(w.Header(), body.algorithm, p.Checksum, "")
+	writeEncryptionHeaders(w.Header(), u.Meta.Encryption)
+	w.WriteHeader(http.StatusOK)
+}
+
+// uploadDataKey returns the data key the parts of an upload are encrypted
+// with, or nil if it is not encrypted.  Parts of an SSE-C upload must be sent
+// with the same customer key as the upload was created with.
+func (h Handler) uploadDataKey(u upload, header http.Header) ([]byte, error) {
+	if _, err := h.dataKey(u.Meta, header, sseCustomerHeaders); err != nil {
+		if err == errSSECustomerKeyRequired {
+			return nil, errUploadEncryptionRequired
+		}
+		return nil, err
+	}
+	return h.openUploadKey(u)
+}
+
+// openUploadKey returns the data key of an upload sealed in u.DataKey.
+func (h Handler) openUploadKey(u upload) ([]byte, error) {
+	if u.DataKey == "" {
+		return nil, nil
+	}
+	master, err := h.masterKey()
+	if err != nil {
+		return nil, err
+	}
+	return openKey(master, u.DataKey)
+}
+
+// storePart stores body as a part of an upload, replacing any part already
+// uploaded with the same number, encrypted with dataKey unless it is nil.
+// The checksum of the part is set when body is a checksumReader.
+func (h Handler) storePart(bucket, uploadID string, partNumber int, body io.Reader, dataKey []byte) (part, error) {
+	target := h.uploadPath(bucket, uploadID, partFileName(partNumber))
+	etag, err := h.storeFile(target, body, dataKey)
+	if err != nil {
+		return part{}, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return part{}, err
+	}
+
+	p := part{
+		PartNumber:   partNumber,
+		ETag:         etag,
+		Size:         info.Size(),
+		LastModified: info.ModTime().UTC(),
+	}
+	if dataKey != nil {
+		p.Size = decryptedSize(p.Size)
+	}
+	if c, ok := body.(*checksumReader); ok {
+		p.Checksum = c.checksum()
+	}
+	return p, h.writeJSON(target+".json", p)
+}
+
+func (h Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	u, err := h.readUpload(bucket, key, r.URL.Query().Get("uploadId"))
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	req := completeMultipartUpload{}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	parts, err := h.readParts(bucket, u.UploadID)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	dataKey, err := h.openUploadKey(u)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	uploaded := make(map[int]part, len(parts))
+	for _, p := range parts {
+		uploaded[p.PartNumber] = p
+	}
+
+	hash := md5.New()
+	composite := checksums{}
+	if alg, ok := findChecksumAlgorithm(u.Meta.ChecksumAlgorithm); ok && u.Meta.ChecksumType == "COMPOSITE" {
+		composite = checksums{{Name: alg.Name, Hash: alg.New()}}
+	}
+	names := make([]string, 0, len(req.Parts))
+	for i, cp := range req.Parts {
+		if i > 0 && cp.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+
+		p, ok := uploaded[cp.PartNumber]
+		if !ok || strings.Trim(cp.ETag, `"`) != p.ETag {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+
+		if i < len(req.Parts)-1 && p.Size < minPartSize {
+			writeError(w, r, errEntityTooSmall)
+			return
+		}
+
+		sum, err := hex.DecodeString(p.ETag)
+		if err != nil {
+			writeError(w, r, errInternalError)
+			return
+		}
+		hash.Write(sum)
+
+		partChecksum, err := base64.StdEncoding.DecodeString(p.Checksum)
+		if err != nil {
+			writeError(w, r, errInternalError)
+			return
+		}
+		composite.Write(partChecksum)
+
+		names = append(names, h.uploadPath(bucket, u.UploadID, partFileName(p.PartNumber)))
+	}
+	partData := &partsReader{names: names, dataKey: dataKey}
+	defer partData.Close()
+
+	meta := u.Meta
+	meta.dataKey = dataKey
+	meta.ETag = fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(req.Parts))
+
+	// A COMPOSITE checksum is the checksum of the checksums of each part,
+	// while a FULL_OBJECT checksum is computed over the whole object.
+	var body io.Reader = partData
+	switch meta.ChecksumType {
+	case "COMPOSITE":
+		meta.Checksum = fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(composite[0].Hash.Sum(nil)), len(req.Parts))
+	case "FULL_OBJECT":
+		if body, err = newChecksumReader(body, http.Header{}, meta.ChecksumAlgorithm); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+
+	meta, err = h.storeObject(bucket, key, body, meta)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	os.RemoveAll(h.uploadPath(bucket, u.UploadID))
+
+	if meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", meta.VersionID)
+	}
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: fmt.Sprintf("%s://%s/%s/%s", requestScheme(r), r.Host, bucket, key),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     quoteETag(meta.ETag),
+	})
+	h.notify(w, r, "ObjectCreated:CompleteMultipartUpload", bucket, key, meta.VersionID)
+}
+
+// partsReader reads the parts of an upload in turn, each decrypted with
+// dataKey unless it is nil.  Only the part being read is open.
+type partsReader struct {
+	names   []string
+	dataKey []byte
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.names) == 0 {
+				return 0, io.EOF
+			}
+			f, err := openData(p.names[0], p.dataKey, 0)
+			if err != nil {
+				return 0, err
+			}
+			p.current, p.names = f, p.names[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			err = p.current.Close()
+			p.current = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// Close closes the part being read, if any.
+func (p *partsReader) Close() error {
+	if p.current == nil {
+		return nil
+	}
+	err := p.current.Close()
+	p.current = nil
+	return err
+}
+
+func (h Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	u, err := h.readUpload(bucket, key, r.URL.Query().Get("uploadId"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if err := os.RemoveAll(h.uploadPath(bucket, u.UploadID)); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) listParts(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	query := r.URL.Query()
+
+	u, err := h.readUpload(bucket, key, query.Get("uploadId"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	maxParts, ok := parseMaxParam(query, "max-parts", defaultMaxParts)
+	if !ok {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	marker := 0
+	if query.Has("part-number-marker") {
+		marker, err = strconv.Atoi(query.Get("part-number-marker"))
+		if err != nil || marker < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+	}
+
+	parts, err := h.readParts(bucket, u.UploadID)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := listPartsResult{
+		Xmlns:            xmlns,
+		Bucket:           bucket,
+		Key:              key,
+		UploadID:         u.UploadID,
+		Initiator:        owner{ID: ownerID, DisplayName: ownerDisplayName},
+		Owner:            owner{ID: ownerID, DisplayName: ownerDisplayName},
+		StorageClass:     "STANDARD",
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, p := range parts {
+		if p.PartNumber <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, partInfo{
+			PartNumber:   p.PartNumber,
+			LastModified: formatISO8601(p.LastModified),
+			ETag:         quoteETag(p.ETag),
+			Size:         p.Size,
+		})
+		result.NextPartNumberMarker = p.PartNumber
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (h Handler) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	maxUploads, ok := parseMaxParam(query, "max-uploads", defaultMaxUploads)
+	if !ok {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	uploadIDMarker := query.Get("upload-id-marker")
+
+	uploads, err := h.readUploads(bucket)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := listMultipartUploadsResult{
+		Xmlns:          xmlns,
+		Bucket:         bucket,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIDMarker,
+		Prefix:         prefix,
+		Delimiter:      delimiter,
+		MaxUploads:     maxUploads,
+	}
+
+	// When an upload ID marker is given, uploads for the marker key are
+	// skipped until the marker upload has been seen.
+	skipping := uploadIDMarker != ""
+	lastPrefix := ""
+	for _, u := range uploads {
+		if !strings.HasPrefix(u.Key, prefix) {
+			continue
+		}
+		if u.Key < keyMarker {
+			continue
+		}
+		if u.Key == keyMarker {
+			if skipping {
+				if u.UploadID == uploadIDMarker {
+					skipping = false
+				}
+				continue
+			}
+			if uploadIDMarker == "" {
+				continue
+			}
+		}
+
+		cp := commonPrefixOf(u.Key, prefix, delimiter)
+		if cp != "" && (cp == lastPrefix || cp == keyMarker) {
+			continue
+		}
+
+		if len(result.Uploads)+len(result.CommonPrefixes) == maxUploads {
+			result.IsTruncated = true
+			break
+		}
+
+		if cp != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: cp})
+			result.NextKeyMarker = cp
+			result.NextUploadIDMarker = ""
+			lastPrefix = cp
+			continue
+		}
+
+		result.Uploads = append(result.Uploads, uploadInfo{
+			Key:          u.Key,
+			UploadID:     u.UploadID,
+			Initiator:    owner{ID: ownerID, DisplayName: ownerDisplayName},
+			Owner:        owner{ID: ownerID, DisplayName: ownerDisplayName},
+			StorageClass: "STANDARD",
+			Initiated:    formatISO8601(u.Initiated),
+		})
+		result.NextKeyMarker = u.Key
+		result.NextUploadIDMarker = u.UploadID
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// readUpload returns the upload with the given ID, if it exists for key.
+func (h Handler) readUpload(bucket, key, uploadID string) (upload, error) {
+	u := upload{}
+	if !uploadIDPattern.MatchString(uploadID) {
+		return u, errNoSuchUpload
+	}
+
+	if err := readJSON(h.uploadPath(bucket, uploadID, "upload.json"), &u); err != nil {
+		if isNotExist(err) {
+			return u, errNoSuchUpload
+		}
+		return u, err
+	}
+
+	if u.Key != key {
+		return u, errNoSuchUpload
+	}
+	return u, nil
+}
+
+// readUploads returns every upload in progress for bucket, sorted by key and
+// then by the time the upload was initiated.
+func (h Handler) readUploads(bucket string) ([]upload, error) {
+	entries, err := os.ReadDir(h.uploadPath(bucket))
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+
+	uploads := []upload{}
+	for _, e := range entries {
+		u := upload{}
+		if err := readJSON(h.uploadPath(bucket, e.Name(), "upload.json"), &u); err != nil {
+			continue
+		}
+		uploads = append(uploads, u)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		if !uploads[i].Initiated.Equal(uploads[j].Initiated) {
+			return uploads[i].Initiated.Before(uploads[j].Initiated)
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	return uploads, nil
+}
+
+// readParts returns every part uploaded so far, sorted by part number.
+func (h Handler) readParts(bucket, uploadID string) ([]part, error) {
+	matches, err := filepath.Glob(h.uploadPath(bucket, uploadID, "*.part.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []part{}
+	for _, m := range matches {
+		p := part{}
+		if err := readJSON(m, &p); err != nil {
+			continue
+		}
+		parts = append(parts, p)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+func partFileName(partNumber int) string {
+	return fmt.Sprintf("%05d.part", partNumber)
+}
+
+// parseMaxParam parses a page size from the query, limited to defaultValue.
+func parseMaxParam(query url.Values, name string, defaultValue int) (int, bool) {
+	if !query.Has(name) {
+		return defaultValue, true
+	}
+	n, err := strconv.Atoi(query.Get(name))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return min(n, defaultValue), true
+}
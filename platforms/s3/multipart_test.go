@@ -0,0 +1,176 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func createUpload(t *testing.T, h Handler, target string) string {
+	t.Helper()
+	res := doRequest(h, http.MethodPost, target+"?uploads", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected create status to be %v got %v", http.StatusOK, res.Code)
+	}
+	result := initiateMultipartUploadResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	return result.UploadID
+}
+
+func uploadPart(t *testing.T, h Handler, target, uploadID string, partNumber int, body string) string {
+	t.Helper()
+	res := doRequest(h, http.MethodPut, fmt.Sprintf("%s?partNumber=%d&uploadId=%s", target, partNumber, uploadID), body)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected upload part status to be %v got %v", http.StatusOK, res.Code)
+	}
+	return res.Header().Get("ETag")
+}
+
+func completeBody(etags ...string) string {
+	b := strings.Builder{}
+	b.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range etags {
+		fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	b.WriteString("</CompleteMultipartUpload>")
+	return b.String()
+}
+
+func TestMultipartUpload(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	target := "/bucket/dir/large.bin"
+
+	part1 := strings.Repeat("a", minPartSize)
+	part2 := "tail"
+
+	uploadID := createUpload(t, h, target)
+	etag1 := uploadPart(t, h, target, uploadID, 1, part1)
+	etag2 := uploadPart(t, h, target, uploadID, 2, part2)
+
+	res := doRequest(h, http.MethodGet, target+"?uploadId="+uploadID, "")
+	parts := listPartsResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &parts); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if len(parts.Parts) != 2 || parts.Parts[1].ETag != etag2 {
+		t.Errorf("expected two parts ending with %v got %+v", etag2, parts.Parts)
+	}
+
+	_, keys, _ := listKeys(t, h, "/bucket?list-type=2")
+	if diff := cmp.Diff([]string{}, keys); diff != "" {
+		t.Errorf("expected staged parts to not be listed: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodPost, target+"?uploadId="+uploadID, completeBody(etag1, etag2))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected complete status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	sum1 := md5.Sum([]byte(part1))
+	sum2 := md5.Sum([]byte(part2))
+	combined := md5.Sum(append(sum1[:], sum2[:]...))
+	wantETag := fmt.Sprintf(`"%s-2"`, hex.EncodeToString(combined[:]))
+
+	result := completeMultipartUploadResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if result.ETag != wantETag {
+		t.Errorf("expected complete etag to be %v got %v", wantETag, result.ETag)
+	}
+	if want := "http://example.com/bucket/dir/large.bin"; result.Location != want {
+		t.Errorf("expected location to be %v got %v", want, result.Location)
+	}
+
+	res = doRequest(h, http.MethodHead, target, "")
+	if got := res.Header().Get("ETag"); got != wantETag {
+		t.Errorf("expected head etag to be %v got %v", wantETag, got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.Directory, "bucket", "dir", "large.bin"))
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if string(data) != part1+part2 {
+		t.Errorf("expected object to be the concatenated parts")
+	}
+
+	res = doRequest(h, http.MethodGet, target+"?uploadId="+uploadID, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected completed upload status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestCompleteMultipartUploadErrors(t *testing.T) {
+	tests := map[string]struct {
+		parts func(etag1, etag2 string) string
+		want  int
+	}{
+		"part too small": {
+			parts: func(etag1, etag2 string) string { return completeBody(etag2, etag2) },
+			want:  http.StatusBadRequest,
+		},
+		"wrong etag": {
+			parts: func(etag1, etag2 string) string { return completeBody(etag1, `"abc"`) },
+			want:  http.StatusBadRequest,
+		},
+		"invalid order": {
+			parts: func(etag1, etag2 string) string {
+				return "<CompleteMultipartUpload><Part><PartNumber>2</PartNumber><ETag>" + etag2 + "</ETag></Part><Part><PartNumber>1</PartNumber><ETag>" + etag1 + "</ETag></Part></CompleteMultipartUpload>"
+			},
+			want: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			target := "/bucket/key"
+			uploadID := createUpload(t, h, target)
+			etag1 := uploadPart(t, h, target, uploadID, 1, "small")
+			etag2 := uploadPart(t, h, target, uploadID, 2, "small")
+
+			res := doRequest(h, http.MethodPost, target+"?uploadId="+uploadID, tc.parts(etag1, etag2))
+			if res.Code != tc.want {
+				t.Errorf("expected status to be %v got %v", tc.want, res.Code)
+			}
+		})
+	}
+}
+
+func TestAbortAndListMultipartUploads(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	keep := createUpload(t, h, "/bucket/b")
+	abort := createUpload(t, h, "/bucket/a")
+	uploadPart(t, h, "/bucket/a", abort, 1, "data")
+
+	res := doRequest(h, http.MethodDelete, "/bucket/a?uploadId="+abort, "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected abort status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/a?uploadId="+abort, "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected second abort status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket?uploads", "")
+	result := listMultipartUploadsResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if len(result.Uploads) != 1 || result.Uploads[0].UploadID != keep {
+		t.Errorf("expected only upload %v got %+v", keep, result.Uploads)
+	}
+}
@@ -0,0 +1,346 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notificationEvents are the event types which can be configured, along
+// with the wildcards covering them.
+var notificationEvents = []string{
+	"s3:ObjectCreated:*",
+	"s3:ObjectCreated:Put",
+	"s3:ObjectCreated:Post",
+	"s3:ObjectCreated:Copy",
+	"s3:ObjectCreated:CompleteMultipartUpload",
+	"s3:ObjectRemoved:*",
+	"s3:ObjectRemoved:Delete",
+	"s3:ObjectRemoved:DeleteMarkerCreated",
+}
+
+// notificationClient delivers events to Handler.NotificationURL.
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// notificationFileMu serializes appends to Handler.NotificationFile.
+var notificationFileMu sync.Mutex
+
+// notificationConfiguration is a bucket's notification configuration.  Each
+// kind of destination is accepted, but events for all of them are delivered
+// to the destinations configured for the Handler.
+type notificationConfiguration struct {
+	XMLName xml.Name             `xml:"NotificationConfiguration" json:"-"`
+	Xmlns   string               `xml:"xmlns,attr,omitempty" json:"-"`
+	Topics  []notificationTarget `xml:"TopicConfiguration" json:"topics,omitempty"`
+	Queues  []notificationTarget `xml:"QueueConfiguration" json:"queues,omitempty"`
+	Lambdas []notificationTarget `xml:"CloudFunctionConfiguration" json:"lambdas,omitempty"`
+}
+
+type notificationTarget struct {
+	ID            string             `xml:"Id,omitempty" json:"id"`
+	Topic         string             `xml:"Topic,omitempty" json:"topic,omitempty"`
+	Queue         string             `xml:"Queue,omitempty" json:"queue,omitempty"`
+	CloudFunction string             `xml:"CloudFunction,omitempty" json:"cloudFunction,omitempty"`
+	Events        []string           `xml:"Event" json:"events"`
+	FilterRules   []notificationRule `xml:"Filter>S3Key>FilterRule" json:"filterRules,omitempty"`
+}
+
+type notificationRule struct {
+	Name  string `xml:"Name" json:"name"`
+	Value string `xml:"Value" json:"value"`
+}
+
+// eventMessage is the JSON document delivered for each event.
+type eventMessage struct {
+	Records []eventRecord `json:"Records"`
+}
+
+type eventRecord struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      eventIdentity     `json:"userIdentity"`
+	RequestParameters map[string]string `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                eventEntity       `json:"s3"`
+}
+
+type eventIdentity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+type eventEntity struct {
+	SchemaVersion   string      `json:"s3SchemaVersion"`
+	ConfigurationID string      `json:"configurationId"`
+	Bucket          eventBucket `json:"bucket"`
+	Object          eventObject `json:"object"`
+}
+
+type eventBucket struct {
+	Name          string        `json:"name"`
+	OwnerIdentity eventIdentity `json:"ownerIdentity"`
+	ARN           string        `json:"arn"`
+}
+
+type eventObject struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+func (h Handler) putBucketNotification(w http.ResponseWriter, r *http.Request, bucket string) {
+	config := notificationConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	if err := validNotificationConfiguration(config); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	// An empty configuration disables notifications for the bucket.
+	target := h.systemPath(bucket, "notification.json")
+	if len(config.Topics)+len(config.Queues)+len(config.Lambdas) == 0 {
+		if err := os.Remove(target); err != nil && !isNotExist(err) {
+			writeError(w, r, errInternalError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := h.writeJSON(target, config); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) getBucketNotification(w http.ResponseWriter, r *http.Request, bucket string) {
+	config, err := h.readNotificationConfiguration(bucket)
+	if err != nil && !isNotExist(err) {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	config.Xmlns = xmlns
+	writeXML(w, http.StatusOK, config)
+}
+
+func (h Handler) readNotificationConfiguration(bucket string) (notificationConfiguration, error) {
+	config := notificationConfiguration{}
+	err := readJSON(h.systemPath(bucket, "notification.json"), &config)
+	return config, err
+}
+
+// validNotificationConfiguration returns the apiError S3 responds with when
+// config is rejected, or nil if it is valid.
+func validNotificationConfiguration(config notificationConfiguration) error {
+	ids := map[string]bool{}
+	for _, t := range config.targets() {
+		if t.ID != "" && ids[t.ID] {
+			err := errInvalidArgument
+			err.Message = "Configuration ids must be unique."
+			return err
+		}
+		ids[t.ID] = true
+
+		if len(t.Events) == 0 {
+			return errMalformedXML
+		}
+		for _, event := range t.Events {
+			if !slices.Contains(notificationEvents, event) {
+				err := errInvalidArgument
+				err.Message = "The event is not supported for notifications"
+				return err
+			}
+		}
+
+		names := map[string]bool{}
+		for _, rule := range t.FilterRules {
+			name := strings.ToLower(rule.Name)
+			err := errInvalidArgument
+			switch {
+			case name != "prefix" && name != "suffix":
+				err.Message = "filter rule name must be either prefix or suffix"
+			case names[name]:
+				err.Message = "Cannot specify more than one " + name + " rule in a filter."
+			default:
+				names[name] = true
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// targets returns the destinations of every kind.
+func (c notificationConfiguration) targets() []notificationTarget {
+	targets := append([]notificationTarget{}, c.Topics...)
+	targets = append(targets, c.Queues...)
+	return append(targets, c.Lambdas...)
+}
+
+// matches reports whether an event for key should be delivered to t.  The
+// event name is without the s3: prefix, such as ObjectCreated:Put.
+func (t notificationTarget) matches(event, key string) bool {
+	for _, rule := range t.FilterRules {
+		switch strings.ToLower(rule.Name) {
+		case "prefix":
+			if !strings.HasPrefix(key, rule.Value) {
+				return false
+			}
+		case "suffix":
+			if !strings.HasSuffix(key, rule.Value) {
+				return false
+			}
+		}
+	}
+
+	for _, pattern := range t.Events {
+		if matchWildcard(pattern, "s3:"+event) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify delivers an event for a key, such as ObjectCreated:Put, to the
+// destinations of every matching configuration of the bucket.  versionID
+// is the version created or removed, if any.
+func (h Handler) notify(w http.ResponseWriter, r *http.Request, event, bucket, key, versionID string) {
+	if h.NotificationURL == "" && h.NotificationFile == "" {
+		return
+	}
+
+	config, err := h.readNotificationConfiguration(bucket)
+	if err != nil {
+		return
+	}
+
+	obj := eventObject{
+		Key:       url.QueryEscape(key),
+		VersionID: versionID,
+		Sequencer: fmt.Sprintf("%016X", timeNow().UnixNano()),
+	}
+	if strings.HasPrefix(event, "ObjectCreated:") {
+		current, err := h.currentObject(bucket, key)
+		if err != nil {
+			return
+		}
+		obj.Size = current.Meta.dataSize(objectSize(current.Info))
+		obj.ETag = current.Meta.ETag
+	}
+
+	principal, region := "anonymous", "us-east-1"
+	if auth, ok := requestCredential(r); ok {
+		principal, region = "AWS:"+auth.AccessKeyID, auth.Region
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	for _, t := range config.targets() {
+		if !t.matches(event, key) {
+			continue
+		}
+
+		record := eventRecord{
+			EventVersion:      "2.1",
+			EventSource:       "aws:s3",
+			AWSRegion:         region,
+			EventTime:         timeNow().UTC().Format("2006-01-02T15:04:05.000Z"),
+			EventName:         event,
+			UserIdentity:      eventIdentity{PrincipalID: principal},
+			RequestParameters: map[string]string{"sourceIPAddress": host},
+			ResponseElements: map[string]string{
+				"x-amz-request-id": w.Header().Get("X-Amz-Request-Id"),
+				"x-amz-id-2":       w.Header().Get("X-Amz-Id-2"),
+			},
+			S3: eventEntity{
+				SchemaVersion:   "1.0",
+				ConfigurationID: t.ID,
+				Bucket: eventBucket{
+					Name:          bucket,
+					OwnerIdentity: eventIdentity{PrincipalID: ownerID},
+					ARN:           "arn:aws:s3:::" + bucket,
+				},
+				Object: obj,
+			},
+		}
+		h.deliverEvent(eventMessage{Records: []eventRecord{record}})
+	}
+}
+
+// deliverEvent appends message to the notification file, and POSTs it to
+// the notification URL in the background.  Failures are logged, as they are
+// not the fault of the request which caused the event.
+func (h Handler) deliverEvent(message eventMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("s3: encoding event: %v", err)
+		return
+	}
+
+	if h.NotificationFile != "" {
+		if err := appendLine(h.NotificationFile, data); err != nil {
+			log.Printf("s3: writing event to %s: %v", h.NotificationFile, err)
+		}
+	}
+
+	if h.NotificationURL != "" {
+		go func(target string) {
+			res, err := notificationClient.Post(target, "application/json", bytes.NewReader(data))
+			if err != nil {
+				log.Printf("s3: delivering event to %s: %v", target, err)
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode >= 300 {
+				log.Printf("s3: delivering event to %s: %s", target, res.Status)
+			}
+		}(h.NotificationURL)
+	}
+}
+
+// appendLine appends data, followed by a newline, to the file name.
+func appendLine(name string, data []byte) error {
+	notificationFileMu.Lock()
+	defer notificationFileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package s3
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const imagesNotification = `<NotificationConfiguration>
+	<QueueConfiguration>
+		<Id>images</Id>
+		<Queue>arn:aws:sqs:us-east-1:000000000000:images</Queue>
+		<Event>s3:ObjectCreated:*</Event>
+		<Event>s3:ObjectRemoved:Delete</Event>
+		<Filter><S3Key>
+			<FilterRule><Name>prefix</Name><Value>images/</Value></FilterRule>
+			<FilterRule><Name>suffix</Name><Value>.jpg</Value></FilterRule>
+		</S3Key></Filter>
+	</QueueConfiguration>
+</NotificationConfiguration>`
+
+// readEvents returns the event name and key of each record in the
+// notification file.
+func readEvents(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	defer f.Close()
+
+	events := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		message := eventMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+		for _, record := range message.Records {
+			events = append(events, record.EventName+" "+record.S3.Object.Key)
+		}
+	}
+	return events
+}
+
+func TestBucketNotification(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.NotificationFile = filepath.Join(t.TempDir(), "events.jsonl")
+
+	res := doRequest(h, http.MethodGet, "/bucket?notification", "")
+	if want := "<NotificationConfiguration xmlns=\"" + xmlns + "\"></NotificationConfiguration>"; !strings.Contains(res.Body.String(), want) {
+		t.Errorf("expected empty configuration got %q", res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket?notification", imagesNotification)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket?notification", "")
+	if want := "<Queue>arn:aws:sqs:us-east-1:000000000000:images</Queue>"; !strings.Contains(res.Body.String(), want) {
+		t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+	}
+
+	doRequest(h, http.MethodPut, "/bucket/images/cat.jpg", "meow")
+	doRequest(h, http.MethodPut, "/bucket/images/cat.png", "meow")
+	doRequest(h, http.MethodPut, "/bucket/docs/cat.jpg", "meow")
+	doRequest(h, http.MethodPut, "/bucket/images/dog.jpg", "", "X-Amz-Copy-Source", "/bucket/images/cat.jpg")
+	doRequest(h, http.MethodDelete, "/bucket/images/cat.jpg", "")
+	doRequest(h, http.MethodDelete, "/bucket/images/cat.png", "")
+
+	want := []string{
+		"ObjectCreated:Put images%2Fcat.jpg",
+		"ObjectCreated:Copy images%2Fdog.jpg",
+		"ObjectRemoved:Delete images%2Fcat.jpg",
+	}
+	if diff := cmp.Diff(want, readEvents(t, h.NotificationFile)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	// An empty configuration disables notifications.
+	doRequest(h, http.MethodPut, "/bucket?notification", "<NotificationConfiguration></NotificationConfiguration>")
+	doRequest(h, http.MethodPut, "/bucket/images/bird.jpg", "tweet")
+	if got := readEvents(t, h.NotificationFile); len(got) != len(want) {
+		t.Errorf("expected %d events got %v", len(want), got)
+	}
+}
+
+func TestNotificationDeleteMarker(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	h.NotificationFile = filepath.Join(t.TempDir(), "events.jsonl")
+
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	doRequest(h, http.MethodPut, "/bucket?notification", `<NotificationConfiguration>
+		<CloudFunctionConfiguration>
+			<Id>removed</Id>
+			<CloudFunction>arn:aws:lambda:us-east-1:000000000000:function:removed</CloudFunction>
+			<Event>s3:ObjectRemoved:*</Event>
+		</CloudFunctionConfiguration>
+	</NotificationConfiguration>`)
+
+	res := doRequest(h, http.MethodPut, "/bucket/key", "data")
+	versionID := res.Header().Get("X-Amz-Version-Id")
+	doRequest(h, http.MethodDelete, "/bucket/key", "")
+	doRequest(h, http.MethodDelete, "/bucket/key?versionId="+versionID, "")
+
+	want := []string{
+		"ObjectRemoved:DeleteMarkerCreated key",
+		"ObjectRemoved:Delete key",
+	}
+	if diff := cmp.Diff(want, readEvents(t, h.NotificationFile)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestNotificationURL(t *testing.T) {
+	received := make(chan eventMessage, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := eventMessage{}
+		json.NewDecoder(r.Body).Decode(&message)
+		received <- message
+	}))
+	defer server.Close()
+
+	h := newTestHandler(t, "bucket")
+	h.NotificationURL = server.URL
+	doRequest(h, http.MethodPut, "/bucket?notification", imagesNotification)
+	res := doRequest(h, http.MethodPut, "/bucket/images/cat.jpg", "meow")
+
+	select {
+	case message := <-received:
+		if len(message.Records) != 1 {
+			t.Fatalf("expected 1 record got %d", len(message.Records))
+		}
+		record := message.Records[0]
+		if record.EventName != "ObjectCreated:Put" {
+			t.Errorf("expected event name to be %q got %q", "ObjectCreated:Put", record.EventName)
+		}
+		if record.S3.ConfigurationID != "images" {
+			t.Errorf("expected configuration ID to be %q got %q", "images", record.S3.ConfigurationID)
+		}
+		if record.S3.Bucket.Name != "bucket" {
+			t.Errorf("expected bucket to be %q got %q", "bucket", record.S3.Bucket.Name)
+		}
+		if record.S3.Object.Size != 4 {
+			t.Errorf("expected size to be %d got %d", 4, record.S3.Object.Size)
+		}
+		if want := strings.Trim(res.Header().Get("ETag"), `"`); record.S3.Object.ETag != want {
+			t.Errorf("expected ETag to be %q got %q", want, record.S3.Object.ETag)
+		}
+		if want := res.Header().Get("X-Amz-Request-Id"); record.ResponseElements["x-amz-request-id"] != want {
+			t.Errorf("expected request ID to be %q got %q", want, record.ResponseElements["x-amz-request-id"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected event to be delivered")
+	}
+}
+
+func TestPutBucketNotificationInvalid(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		"malformed": {
+			body:     "<NotificationConfiguration>",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>MalformedXML</Code>",
+		},
+		"unsupported event": {
+			body:     "<NotificationConfiguration><QueueConfiguration><Queue>q</Queue><Event>s3:ObjectRestore:*</Event></QueueConfiguration></NotificationConfiguration>",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Message>The event is not supported for notifications</Message>",
+		},
+		"filter name": {
+			body:     "<NotificationConfiguration><QueueConfiguration><Queue>q</Queue><Event>s3:ObjectCreated:*</Event><Filter><S3Key><FilterRule><Name>middle</Name><Value>x</Value></FilterRule></S3Key></Filter></QueueConfiguration></NotificationConfiguration>",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Message>filter rule name must be either prefix or suffix</Message>",
+		},
+		"duplicate prefix": {
+			body:     "<NotificationConfiguration><QueueConfiguration><Queue>q</Queue><Event>s3:ObjectCreated:*</Event><Filter><S3Key><FilterRule><Name>prefix</Name><Value>a</Value></FilterRule><FilterRule><Name>Prefix</Name><Value>b</Value></FilterRule></S3Key></Filter></QueueConfiguration></NotificationConfiguration>",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Message>Cannot specify more than one prefix rule in a filter.</Message>",
+		},
+		"duplicate id": {
+			body:     "<NotificationConfiguration><QueueConfiguration><Id>a</Id><Queue>q</Queue><Event>s3:ObjectCreated:*</Event></QueueConfiguration><TopicConfiguration><Id>a</Id><Topic>t</Topic><Event>s3:ObjectCreated:*</Event></TopicConfiguration></NotificationConfiguration>",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>InvalidArgument</Code>",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := doRequest(h, http.MethodPut, "/bucket?notification", tc.body)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d", tc.wantCode, res.Code)
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
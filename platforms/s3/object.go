@@ -0,0 +1,514 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const defaultContentType = "binary/octet-stream"
+
+// maxObjectSize is the largest object which can be uploaded in a single PUT.
+const maxObjectSize = 5 * 1024 * 1024 * 1024
+
+var errNotFile = errors.New("s3: object path is not a regular file")
+
+func (h Handler) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if payloadLength(r) > maxObjectSize {
+		writeError(w, r, errEntityTooLarge)
+		return
+	}
+
+	meta, err := newObjectMeta(r.Header)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+	meta.Encryption, meta.dataKey, err = h.newEncryption(r.Header)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if isFolderKey(key) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		if n != 0 {
+			writeError(w, r, errFolderContent)
+			return
+		}
+		if err := h.storeFolder(bucket, key, meta); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", quoteETag(emptyMD5))
+		w.WriteHeader(http.StatusOK)
+		h.notify(w, r, "ObjectCreated:Put", bucket, key, "")
+		return
+	}
+
+	body, err := newChecksumReader(r.Body, r.Header, "")
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	meta, err = h.storeObject(bucket, key, body, meta)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(meta.ETag))
+	writeChecksumHeaders(w.Header(), meta.ChecksumAlgorithm, meta.Checksum, "")
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+	if meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", meta.VersionID)
+	}
+	w.WriteHeader(http.StatusOK)
+	h.notify(w, r, "ObjectCreated:Put", bucket, key, meta.VersionID)
+}
+
+// storeObject stores body as the current version of an object, first
+// preserving the version it replaces when the bucket is versioned.  The
+// ETag is set to the MD5 of body unless meta already has one, and the
+// checksum is set when body is a checksumReader.  The data is encrypted
+// when meta has a data key.
+func (h Handler) storeObject(bucket, key string, body io.Reader, meta objectMeta) (objectMeta, error) {
+	versionID, err := h.newVersion(bucket, key)
+	if err != nil {
+		return meta, err
+	}
+	meta.VersionID = versionID
+
+	etag, err := h.storeFile(h.objectPath(bucket, key), body, meta.dataKey)
+	if err != nil {
+		return meta, err
+	}
+	if meta.ETag == "" {
+		meta.ETag = etag
+	}
+	if c, ok := body.(*checksumReader); ok && c.algorithm != "" {
+		meta.ChecksumAlgorithm, meta.Checksum = c.algorithm, c.checksum()
+	}
+
+	return meta, h.writeMeta(bucket, key, meta)
+}
+
+// storeFolder creates the directory for a folder key, storing the tags in
+// meta, if any.
+func (h Handler) storeFolder(bucket, key string, meta objectMeta) error {
+	if err := os.MkdirAll(h.objectPath(bucket, key), 0755); err != nil {
+		return errNotFile
+	}
+	if len(meta.Tags) == 0 {
+		h.removeMeta(bucket, key)
+		return nil
+	}
+	return h.writeMeta(bucket, key, objectMeta{Tags: meta.Tags})
+}
+
+func (h Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	h.readObject(w, r, bucket, key, true)
+}
+
+func (h Handler) headObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	h.readObject(w, r, bucket, key, false)
+}
+
+// readObject responds to GET and HEAD requests for an object, evaluating
+// any conditional and Range headers.  Objects encrypted with SSE-C can only
+// be read with their key.
+func (h Handler) readObject(w http.ResponseWriter, r *http.Request, bucket, key string, withBody bool) {
+	obj, err := h.findObject(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeVersionError(w, r, err)
+		return
+	}
+	info, meta := obj.Info, obj.Meta
+
+	dataKey, err := h.dataKey(meta, r.Header, sseCustomerHeaders)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	switch requestConditions.evaluate(r.Header, meta.ETag, info.ModTime()) {
+	case conditionNotModified:
+		w.Header().Set("ETag", quoteETag(meta.ETag))
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case conditionFailed:
+		writeError(w, r, errPreconditionFailed)
+		return
+	}
+
+	size := meta.dataSize(objectSize(info))
+	rng, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, r, errInvalidRange)
+		return
+	}
+
+	h.writeObjectHeaders(w, r, obj)
+
+	statusCode := http.StatusOK
+	offset, length := int64(0), size
+	if rng != nil {
+		statusCode = http.StatusPartialContent
+		offset, length = rng.Start, rng.Length
+		w.Header().Set("Content-Range", rng.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+
+	// Checksums are only returned when the whole object is requested.
+	if rng == nil && strings.EqualFold(r.Header.Get("X-Amz-Checksum-Mode"), "ENABLED") {
+		checksumType := meta.ChecksumType
+		if checksumType == "" {
+			checksumType = "FULL_OBJECT"
+		}
+		writeChecksumHeaders(w.Header(), meta.ChecksumAlgorithm, meta.Checksum, checksumType)
+	}
+
+	if !withBody || info.IsDir() {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	f, err := openData(obj.Name, dataKey, offset)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	defer f.Close()
+
+	w.WriteHeader(statusCode)
+	io.CopyN(w, f, length)
+}
+
+func (h Handler) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var deleted deletedObject
+	var err error
+	query := r.URL.Query()
+	if query.Has("versionId") {
+		deleted, err = h.deleteObjectVersion(bucket, key, query.Get("versionId"))
+	} else {
+		deleted, err = h.deleteCurrentObject(bucket, key)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if deleted.DeleteMarker {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
+	if deleted.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", deleted.VersionID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	h.notify(w, r, deleted.event(query.Has("versionId")), bucket, key, deleted.VersionID)
+}
+
+// deletedObject describes the result of deleting an object.
+type deletedObject struct {
+	// VersionID is the version deleted, or the delete marker created, in
+	// versioned buckets.
+	VersionID string
+	// DeleteMarker is true when a delete marker was created or deleted.
+	DeleteMarker bool
+}
+
+// event returns the name of the notification event for the deletion, which
+// created a delete marker unless a version was deleted.
+func (d deletedObject) event(versionDeleted bool) string {
+	if d.DeleteMarker && !versionDeleted {
+		return "ObjectRemoved:DeleteMarkerCreated"
+	}
+	return "ObjectRemoved:Delete"
+}
+
+// deleteCurrentObject deletes the current version of an object, creating a
+// delete marker in its place if the bucket is versioned.
+func (h Handler) deleteCurrentObject(bucket, key string) (deletedObject, error) {
+	if !isFolderKey(key) && h.versioningStatus(bucket) != "" {
+		marker, err := h.createDeleteMarker(bucket, key)
+		if err != nil {
+			return deletedObject{}, err
+		}
+		return deletedObject{VersionID: marker.VersionID, DeleteMarker: true}, nil
+	}
+
+	return deletedObject{}, h.removeObject(bucket, key)
+}
+
+// removeObject removes the current version of an object, if it exists.
+func (h Handler) removeObject(bucket, key string) error {
+	if _, err := h.statObject(bucket, key); err != nil {
+		return nil
+	}
+
+	target := h.objectPath(bucket, key)
+	if err := os.Remove(target); err != nil && !isNotExist(err) {
+		return err
+	}
+	h.removeMeta(bucket, key)
+	removeEmptyParents(h.bucketPath(bucket), filepath.Dir(target))
+	return nil
+}
+
+// writeObjectHeaders sets the headers shared by GET and HEAD requests.
+func (h Handler) writeObjectHeaders(w http.ResponseWriter, r *http.Request, obj objectFile) {
+	info, meta := obj.Info, obj.Meta
+	meta.writeHeaders(w.Header())
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", detectContentType(obj))
+	}
+	for param, name := range responseHeaderOverrides {
+		if v := r.URL.Query().Get(param); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.dataSize(objectSize(info)), 10))
+	w.Header().Set("ETag", quoteETag(meta.ETag))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", meta.VersionID)
+	}
+	if len(meta.Tags) != 0 {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(len(meta.Tags)))
+	}
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+}
+
+// responseHeaderOverrides maps the query parameters of a GET request to the
+// response headers they replace.
+var responseHeaderOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// detectContentType returns the content type of an object which was not
+// uploaded with one, such as files copied directly into a bucket.
+func detectContentType(obj objectFile) string {
+	if obj.Info.IsDir() || obj.Meta.Encryption != nil {
+		return defaultContentType
+	}
+	m, err := mimetype.DetectFile(obj.Name)
+	if err != nil {
+		return defaultContentType
+	}
+	return m.String()
+}
+
+// objectFile is a version of an object and the file holding its data.
+type objectFile struct {
+	Name string
+	Info os.FileInfo
+	Meta objectMeta
+}
+
+// currentObject returns the current version of an object.
+func (h Handler) currentObject(bucket, key string) (objectFile, error) {
+	info, err := h.statObject(bucket, key)
+	if err != nil {
+		return objectFile{}, err
+	}
+	meta, err := h.loadMeta(bucket, key, info)
+	if err != nil {
+		return objectFile{}, err
+	}
+	return objectFile{Name: h.objectPath(bucket, key), Info: info, Meta: meta}, nil
+}
+
+// statObject returns the file info for an object.  Folder keys (ending in a
+// slash) only exist as empty directories, all other keys must be regular
+// files.
+func (h Handler) statObject(bucket, key string) (os.FileInfo, error) {
+	info, err := os.Stat(h.objectPath(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+
+	if isFolderKey(key) {
+		if !info.IsDir() {
+			return nil, os.ErrNotExist
+		}
+		entries, err := os.ReadDir(h.objectPath(bucket, key))
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 0 {
+			return nil, os.ErrNotExist
+		}
+		return info, nil
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, os.ErrNotExist
+	}
+	return info, nil
+}
+
+// storeFile atomically writes the contents of body to target, creating any
+// missing parent directories, and returns the hex encoded MD5 of the data.
+// The data is encrypted with dataKey, unless it is nil.
+func (h Handler) storeFile(target string, body io.Reader, dataKey []byte) (string, error) {
+	if info, err := os.Stat(target); err == nil && !info.Mode().IsRegular() {
+		return "", errNotFile
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		if isNotExist(err) || errors.Is(err, os.ErrExist) {
+			return "", errNotFile
+		}
+		return "", err
+	}
+
+	tmp, err := h.createTemp()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	err = copyData(tmp, io.TeeReader(body, hash), dataKey)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyData copies body to w, encrypting it with dataKey unless it is nil.
+func copyData(w io.Writer, body io.Reader, dataKey []byte) error {
+	if dataKey == nil {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	enc, err := newEncryptWriter(w, dataKey)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, body); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// openData returns the data held by the file name from offset, decrypting
+// it with dataKey unless it is nil.
+func openData(name string, dataKey []byte, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey == nil {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d, err := newDecryptReader(f, info.Size(), dataKey, offset)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{d, f}, nil
+}
+
+// writeStoreError writes the response for an error returned by storeFile.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := apiError{}
+	switch {
+	case errors.Is(err, errNotFile):
+		writeError(w, r, errKeyConflict)
+	case errors.As(err, &apiErr):
+		writeError(w, r, apiErr)
+	default:
+		writeError(w, r, errInternalError)
+	}
+}
+
+// createTemp returns a new file in the system directory which can later be
+// renamed into place.
+func (h Handler) createTemp() (*os.File, error) {
+	dir := h.systemPath("tmp")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "upload-")
+}
+
+// removeEmptyParents removes dir, and each of its parents, until a non-empty
+// directory or root itself is reached.
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+const emptyMD5 = "d41d8cd98f00b204e9800998ecf8427e"
+
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+func isFolderKey(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
@@ -0,0 +1,643 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxPolicySize is the maximum size in bytes of a bucket policy.
+const maxPolicySize = 20 * 1024
+
+// accountID is the AWS account every access key is treated as belonging to
+// when matching the principals of a bucket policy.
+const accountID = "000000000000"
+
+// policyConditionOperators are the condition operators bucket policies may
+// use.
+var policyConditionOperators = []string{
+	"StringEquals",
+	"StringNotEquals",
+	"StringEqualsIgnoreCase",
+	"StringNotEqualsIgnoreCase",
+	"StringLike",
+	"StringNotLike",
+	"IpAddress",
+	"NotIpAddress",
+	"Bool",
+}
+
+// principalKey is the context key s3.auth stores the principal of a request
+// under.
+type principalKey struct{}
+
+// principal identifies who sent a request by the access key it was signed
+// with.  Anonymous requests have no access key.
+type principal struct {
+	AccessKeyID string
+	// Session is set for requests signed with temporary credentials issued
+	// by STS.
+	Session *session
+}
+
+// user returns the access key of the IAM user a principal acts as, which
+// for temporary credentials is the access key they were issued to.
+func (p principal) user() string {
+	if p.Session != nil {
+		return p.Session.Parent
+	}
+	return p.AccessKeyID
+}
+
+// role returns the ARN of the role assumed by a principal, if any.
+func (p principal) role() string {
+	if p.Session != nil {
+		return p.Session.RoleARN
+	}
+	return ""
+}
+
+// arn returns the ARN of a signed principal, either an IAM user named after
+// its access key or an assumed role session.
+func (p principal) arn() string {
+	if role := p.role(); role != "" {
+		name := role[strings.LastIndex(role, "/")+1:]
+		return "arn:aws:sts::" + accountID + ":assumed-role/" + name + "/" + p.Session.SessionName
+	}
+	return "arn:aws:iam::" + accountID + ":user/" + p.user()
+}
+
+// userID returns the aws:userid of a signed principal.
+func (p principal) userID() string {
+	if role := p.role(); role != "" {
+		return roleID(role) + ":" + p.Session.SessionName
+	}
+	return p.user()
+}
+
+// requestPrincipal returns the principal s3.auth authenticated r as, if r
+// has been through s3.auth.
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// bucketPolicy is a bucket's access policy document.
+type bucketPolicy struct {
+	Version   string           `json:"Version"`
+	ID        string           `json:"Id"`
+	Statement policyStatements `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid         string                           `json:"Sid"`
+	Effect      string                           `json:"Effect"`
+	Principal   policyPrincipal                  `json:"Principal"`
+	Action      stringList                       `json:"Action"`
+	NotAction   stringList                       `json:"NotAction"`
+	Resource    stringList                       `json:"Resource"`
+	NotResource stringList                       `json:"NotResource"`
+	Condition   map[string]map[string]stringList `json:"Condition"`
+}
+
+// policyStatements is the Statement of a policy, which is either a single
+// statement or a list of them.
+type policyStatements []policyStatement
+
+func (s *policyStatements) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) != 0 && data[0] == '{' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*[]policyStatement)(s))
+}
+
+// policyPrincipal maps each kind of principal to the principals of that
+// kind a statement applies to.  A principal of "*" applies to everyone.
+type policyPrincipal map[string]stringList
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "*" {
+			return fmt.Errorf("invalid principal %q", s)
+		}
+		*p = policyPrincipal{"AWS": {"*"}}
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]stringList)(p))
+}
+
+// stringList is a policy value which is either a single value or a list of
+// them.  Numbers and booleans are kept as they were written.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	values := []any{}
+	if err := decoder.Decode(&values); err != nil {
+		return err
+	}
+
+	*l = make(stringList, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			*l = append(*l, v)
+		case json.Number, bool:
+			*l = append(*l, fmt.Sprint(v))
+		default:
+			return fmt.Errorf("invalid value %v", v)
+		}
+	}
+	return nil
+}
+
+func (h Handler) putBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicySize+1))
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+	if len(data) > maxPolicySize {
+		io.Copy(io.Discard, r.Body)
+		err := errMalformedPolicy
+		err.Message = "Policy exceeds the maximum allowed document size."
+		writeError(w, r, err)
+		return
+	}
+
+	policy, err := parseBucketPolicy(data)
+	if err != nil {
+		writeError(w, r, errMalformedPolicy)
+		return
+	}
+	if err := validBucketPolicy(policy, bucket); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if err := h.writeJSON(h.systemPath(bucket, "policy.json"), json.RawMessage(data)); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) getBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	data, err := os.ReadFile(h.systemPath(bucket, "policy.json"))
+	if err != nil {
+		if isNotExist(err) {
+			writeError(w, r, errNoSuchBucketPolicy)
+			return
+		}
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (h Handler) deleteBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := os.Remove(h.systemPath(bucket, "policy.json")); err != nil && !isNotExist(err) {
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readBucketPolicy returns the policy of a bucket.
+func (h Handler) readBucketPolicy(bucket string) (bucketPolicy, error) {
+	data, err := os.ReadFile(h.systemPath(bucket, "policy.json"))
+	if err != nil {
+		return bucketPolicy{}, err
+	}
+	return parseBucketPolicy(data)
+}
+
+func parseBucketPolicy(data []byte) (bucketPolicy, error) {
+	policy := bucketPolicy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
+	return policy, err
+}
+
+// validBucketPolicy returns the apiError S3 responds with when the policy
+// of bucket is rejected, or nil if it is valid.
+func validBucketPolicy(policy bucketPolicy, bucket string) error {
+	malformed := func(message string) error {
+		err := errMalformedPolicy
+		err.Message = message
+		return err
+	}
+
+	switch policy.Version {
+	case "", "2008-10-17", "2012-10-17":
+	default:
+		return malformed("Invalid policy version")
+	}
+	if len(policy.Statement) == 0 {
+		return malformed("Missing required field Statement")
+	}
+
+	for _, s := range policy.Statement {
+		if s.Effect != "Allow" && s.Effect != "Deny" {
+			return malformed("Invalid effect: " + s.Effect)
+		}
+		if len(s.Principal) == 0 {
+			return malformed("Missing required field Principal")
+		}
+		for kind := range s.Principal {
+			if kind != "AWS" && kind != "CanonicalUser" {
+				return malformed("Invalid principal in policy")
+			}
+		}
+
+		if (s.Action == nil) == (s.NotAction == nil) {
+			return malformed("Missing required field Action")
+		}
+		for _, action := range append(s.Action, s.NotAction...) {
+			if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+				return malformed("Policy has invalid action")
+			}
+		}
+
+		if (s.Resource == nil) == (s.NotResource == nil) {
+			return malformed("Missing required field Resource")
+		}
+		for _, resource := range append(s.Resource, s.NotResource...) {
+			rest, ok := strings.CutPrefix(resource, bucketARN(bucket))
+			if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+				return malformed("Policy has invalid resource")
+			}
+		}
+
+		for operator, values := range s.Condition {
+			known := func(v string) bool { return strings.EqualFold(v, operator) }
+			if !slices.ContainsFunc(policyConditionOperators, known) {
+				return malformed("Invalid Condition type : " + operator)
+			}
+			if !strings.HasSuffix(strings.ToLower(operator), "ipaddress") {
+				continue
+			}
+			for _, addrs := range values {
+				for _, addr := range addrs {
+					if _, err := parsePrefix(addr); err != nil {
+						return malformed("Invalid IP address: " + addr)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// authorize returns errAccessDenied if the policy of bucket denies the
+// action, or if r is anonymous and the policy does not allow it.  Requests
+// which have not been through s3.auth are only subject to Deny statements.
+func (h Handler) authorize(r *http.Request, action, bucket, key string) error {
+	p, authenticated := requestPrincipal(r)
+
+	policy := bucketPolicy{}
+	if bucket != "" {
+		var err error
+		policy, err = h.readBucketPolicy(bucket)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+	}
+
+	resource := bucketARN(bucket)
+	if key != "" {
+		resource += "/" + key
+	}
+
+	switch policy.evaluate(r, p, action, resource) {
+	case "Deny":
+		return errAccessDenied
+	case "Allow":
+		return nil
+	}
+	if authenticated && p.AccessKeyID == "" {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// evaluate returns the effect of the policy on a request by p to perform
+// action on resource, which is Deny if any statement denies it, Allow if
+// any allows it, and otherwise empty.
+func (policy bucketPolicy) evaluate(r *http.Request, p principal, action, resource string) string {
+	effect := ""
+	for _, s := range policy.Statement {
+		if !s.matches(r, p, action, resource) {
+			continue
+		}
+		if s.Effect == "Deny" {
+			return "Deny"
+		}
+		effect = "Allow"
+	}
+	return effect
+}
+
+func (s policyStatement) matches(r *http.Request, p principal, action, resource string) bool {
+	if !s.Principal.matches(p) {
+		return false
+	}
+
+	actionMatches := func(pattern string) bool {
+		return matchPattern(strings.ToLower(pattern), strings.ToLower(action))
+	}
+	if s.Action != nil && !slices.ContainsFunc(s.Action, actionMatches) {
+		return false
+	}
+	if s.NotAction != nil && slices.ContainsFunc(s.NotAction, actionMatches) {
+		return false
+	}
+
+	resourceMatches := func(pattern string) bool {
+		return matchPattern(pattern, resource)
+	}
+	if s.Resource != nil && !slices.ContainsFunc(s.Resource, resourceMatches) {
+		return false
+	}
+	if s.NotResource != nil && slices.ContainsFunc(s.NotResource, resourceMatches) {
+		return false
+	}
+
+	for operator, values := range s.Condition {
+		for key, want := range values {
+			value, ok := conditionValue(r, p, key)
+			if !conditionMatches(operator, value, ok, want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matches reports whether the principals include p.  Signed requests are
+// made by an IAM user named after their access key in accountID, or by a
+// session of a role assumed with STS, anonymous requests are only matched
+// by "*".
+func (pp policyPrincipal) matches(p principal) bool {
+	for _, v := range pp["AWS"] {
+		switch {
+		case v == "*":
+			return true
+		case p.AccessKeyID == "":
+		case v == p.AccessKeyID, v == accountID, v == "arn:aws:iam::"+accountID+":root", v == p.arn():
+			return true
+		case p.role() != "" && v == p.role():
+			return true
+		}
+	}
+	for _, v := range pp["CanonicalUser"] {
+		if p.AccessKeyID != "" && v == ownerID {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionValue returns the value of a condition key for r, if it has one.
+func conditionValue(r *http.Request, p principal, key string) (string, bool) {
+	query := r.URL.Query()
+	switch strings.ToLower(key) {
+	case "aws:sourceip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return host, true
+	case "aws:securetransport":
+		return strconv.FormatBool(r.TLS != nil), true
+	case "aws:referer":
+		return r.Header.Get("Referer"), r.Header.Get("Referer") != ""
+	case "aws:useragent":
+		return r.Header.Get("User-Agent"), r.Header.Get("User-Agent") != ""
+	case "aws:principaltype":
+		switch {
+		case p.AccessKeyID == "":
+			return "Anonymous", true
+		case p.role() != "":
+			return "AssumedRole", true
+		}
+		return "User", true
+	case "aws:userid":
+		return p.userID(), p.AccessKeyID != ""
+	case "aws:username":
+		return p.user(), p.AccessKeyID != "" && p.role() == ""
+	case "s3:prefix":
+		return query.Get("prefix"), query.Has("prefix")
+	case "s3:delimiter":
+		return query.Get("delimiter"), query.Has("delimiter")
+	case "s3:max-keys":
+		return query.Get("max-keys"), query.Has("max-keys")
+	case "s3:versionid":
+		return query.Get("versionId"), query.Has("versionId")
+	}
+	return "", false
+}
+
+// conditionMatches reports whether a condition of a statement is met by the
+// value of its key, which ok reports the request has.  The negated
+// operators are met when the request does not have the key.
+func conditionMatches(operator, value string, ok bool, want stringList) bool {
+	var match func(string) bool
+	switch strings.ToLower(operator) {
+	case "stringequals", "stringnotequals":
+		match = func(v string) bool { return v == value }
+	case "stringequalsignorecase", "stringnotequalsignorecase":
+		match = func(v string) bool { return strings.EqualFold(v, value) }
+	case "stringlike", "stringnotlike":
+		match = func(v string) bool { return matchPattern(v, value) }
+	case "bool":
+		match = func(v string) bool { return strings.EqualFold(v, value) }
+	case "ipaddress", "notipaddress":
+		addr, err := netip.ParseAddr(value)
+		match = func(v string) bool {
+			prefix, perr := parsePrefix(v)
+			return err == nil && perr == nil && prefix.Contains(addr.Unmap())
+		}
+	default:
+		return false
+	}
+	negated := strings.Contains(strings.ToLower(operator), "not")
+	if !ok {
+		return negated
+	}
+	return slices.ContainsFunc(want, match) != negated
+}
+
+// parsePrefix parses an IP address condition value, which is either an
+// address or a CIDR block.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// requestAction returns the action a policy must allow for r, which is
+// addressed to key, or empty if the action is authorized by the handler
+// once it has read the request.
+func requestAction(r *http.Request, key string) string {
+	query := r.URL.Query()
+	versioned := query.Has("versionId")
+	if key != "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			switch {
+			case query.Has("uploadId"):
+				return "s3:ListMultipartUploadParts"
+			case query.Has("tagging") && versioned:
+				return "s3:GetObjectVersionTagging"
+			case query.Has("tagging"):
+				return "s3:GetObjectTagging"
+			case versioned:
+				return "s3:GetObjectVersion"
+			}
+			return "s3:GetObject"
+		case http.MethodPut:
+			switch {
+			case query.Has("tagging") && versioned:
+				return "s3:PutObjectVersionTagging"
+			case query.Has("tagging"):
+				return "s3:PutObjectTagging"
+			}
+			return "s3:PutObject"
+		case http.MethodPost:
+			return "s3:PutObject"
+		case http.MethodDelete:
+			switch {
+			case query.Has("uploadId"):
+				return "s3:AbortMultipartUpload"
+			case query.Has("tagging") && versioned:
+				return "s3:DeleteObjectVersionTagging"
+			case query.Has("tagging"):
+				return "s3:DeleteObjectTagging"
+			case versioned:
+				return "s3:DeleteObjectVersion"
+			}
+			return "s3:DeleteObject"
+		}
+		return ""
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case query.Has("uploads"):
+			return "s3:ListBucketMultipartUploads"
+		case query.Has("cors"):
+			return "s3:GetBucketCORS"
+		case query.Has("lifecycle"):
+			return "s3:GetLifecycleConfiguration"
+		case query.Has("notification"):
+			return "s3:GetBucketNotification"
+		case query.Has("policy"):
+			return "s3:GetBucketPolicy"
+		case query.Has("tagging"):
+			return "s3:GetBucketTagging"
+		case query.Has("versioning"):
+			return "s3:GetBucketVersioning"
+		case query.Has("versions"):
+			return "s3:ListBucketVersions"
+		}
+		return "s3:ListBucket"
+	case http.MethodPut:
+		switch {
+		case query.Has("cors"):
+			return "s3:PutBucketCORS"
+		case query.Has("lifecycle"):
+			return "s3:PutLifecycleConfiguration"
+		case query.Has("notification"):
+			return "s3:PutBucketNotification"
+		case query.Has("policy"):
+			return "s3:PutBucketPolicy"
+		case query.Has("tagging"):
+			return "s3:PutBucketTagging"
+		case query.Has("versioning"):
+			return "s3:PutBucketVersioning"
+		}
+		return "s3:CreateBucket"
+	case http.MethodDelete:
+		switch {
+		case query.Has("cors"):
+			return "s3:PutBucketCORS"
+		case query.Has("lifecycle"):
+			return "s3:PutLifecycleConfiguration"
+		case query.Has("policy"):
+			return "s3:DeleteBucketPolicy"
+		case query.Has("tagging"):
+			return "s3:PutBucketTagging"
+		}
+		return "s3:DeleteBucket"
+	}
+
+	// DeleteObjects and POST uploads are authorized for each key.
+	return ""
+}
+
+func bucketARN(bucket string) string {
+	return "arn:aws:s3:::" + bucket
+}
+
+// matchPattern reports whether s matches pattern, where * matches any
+// sequence of characters and ? matches any single character.
+func matchPattern(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			// Let the last * absorb one more character and retry.
+			mark++
+			p, i = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,420 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPostFieldsSize limits the total size of the form fields preceding the
+// file of a POST upload.
+const maxPostFieldsSize = 20 * 1024
+
+// credentialsKey is the context key s3.auth stores its credentials under
+// for POST uploads, which are signed by the policy in the form rather than
+// the request itself.
+type credentialsKey struct{}
+
+type postResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// postPolicy is the policy document of a POST upload.
+type postPolicy struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+// policyCondition is a condition of a policy document, which is either an
+// exact match, a starts-with match or a content-length-range.
+type policyCondition struct {
+	Op    string
+	Field string
+	Value string
+	Min   int64
+	Max   int64
+	// Raw is the condition as it appeared in the policy.
+	Raw string
+}
+
+// isPostObject reports whether r is a browser based upload, sent as a
+// multipart/form-data POST.
+func isPostObject(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && mediaType == "multipart/form-data"
+}
+
+func (h Handler) postObject(w http.ResponseWriter, r *http.Request, bucket string) {
+	defer io.Copy(io.Discard, r.Body)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, errMalformedPOSTRequest)
+		return
+	}
+
+	// Form fields precede the file, any fields after it are ignored.
+	fields := map[string]string{}
+	size := 0
+	var file io.Reader
+	filename := ""
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, r, errMalformedPOSTRequest)
+			return
+		}
+
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			file, filename = part, part.FileName()
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, int64(maxPostFieldsSize-size+1)))
+		size += len(name) + len(value)
+		if err != nil || size > maxPostFieldsSize {
+			writeError(w, r, errMaxPostPreDataLengthExceeded)
+			return
+		}
+		fields[name] = string(value)
+	}
+
+	if file == nil {
+		writeError(w, r, errMissingPOSTFile)
+		return
+	}
+	if fields["key"] == "" {
+		writeError(w, r, errMissingPOSTKey)
+		return
+	}
+	key := strings.ReplaceAll(fields["key"], "${filename}", filename)
+	fields["key"] = key
+	if err := validKey(key); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if isFolderKey(key) {
+		writeError(w, r, errInvalidKey)
+		return
+	}
+
+	conditions, err := verifyPostPolicy(r, bucket, fields)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	// Uploads without a policy are anonymous, so must be allowed by the
+	// bucket policy.
+	if err := h.authorize(postPrincipal(r, fields), "s3:PutObject", bucket, key); err != nil {
+		if _, ok := fields["policy"]; ok || err != errAccessDenied {
+			writeStoreError(w, r, err)
+			return
+		}
+		writeError(w, r, errPolicyRequired)
+		return
+	}
+
+	header := http.Header{}
+	for name, value := range fields {
+		header.Set(name, value)
+	}
+	header.Del("X-Amz-Tagging")
+	meta, err := newObjectMeta(header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if v, ok := fields["tagging"]; ok {
+		if meta.Tags, err = readTagging(strings.NewReader(v), maxObjectTags); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+	meta.Encryption, meta.dataKey, err = h.newEncryption(header)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	body := &lengthRangeReader{r: file, min: 0, max: maxObjectSize}
+	for _, c := range conditions {
+		if c.Op == "content-length-range" {
+			body.min, body.max = c.Min, c.Max
+		}
+	}
+
+	meta, err = h.storeObject(bucket, key, body, meta)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	etag := quoteETag(meta.ETag)
+	location := fmt.Sprintf("http://%s/%s/%s", r.Host, bucket, uriEncode(key, false))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", location)
+	if meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", meta.VersionID)
+	}
+	writeEncryptionHeaders(w.Header(), meta.Encryption)
+
+	defer h.notify(w, r, "ObjectCreated:Post", bucket, key, meta.VersionID)
+
+	if redirect, err := url.Parse(fields["success_action_redirect"]); err == nil && redirect.IsAbs() {
+		query := redirect.Query()
+		query.Set("bucket", bucket)
+		query.Set("key", key)
+		query.Set("etag", etag)
+		redirect.RawQuery = query.Encode()
+		w.Header().Set("Location", redirect.String())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		writeXML(w, http.StatusCreated, postResponse{Location: location, Bucket: bucket, Key: key, ETag: etag})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verifyPostPolicy verifies the signature of the policy of a POST upload,
+// when s3.auth is used, and that the form fields meet its conditions.
+func verifyPostPolicy(r *http.Request, bucket string, fields map[string]string) ([]policyCondition, error) {
+	creds, signed := r.Context().Value(credentialsKey{}).(credentials)
+	encoded, ok := fields["policy"]
+	if !ok {
+		return nil, nil
+	}
+
+	if signed {
+		if err := verifyPolicySignature(creds, encoded, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidPolicyDocument
+	}
+	policy := postPolicy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, errInvalidPolicyDocument
+	}
+
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return nil, errInvalidPolicyDocument
+	}
+	if timeNow().After(expiration) {
+		return nil, policyError("Policy expired.")
+	}
+
+	conditions := make([]policyCondition, 0, len(policy.Conditions))
+	for _, raw := range policy.Conditions {
+		c, err := parsePolicyCondition(raw)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+
+	// The bucket is the one addressed, whatever bucket field is sent, so a
+	// policy signed for one bucket can not upload to another.
+	values := map[string]string{}
+	for name, value := range fields {
+		values[name] = value
+	}
+	values["bucket"] = bucket
+
+	covered := map[string]bool{}
+	for _, c := range conditions {
+		covered[c.Field] = true
+		if !c.matches(values[c.Field]) {
+			return nil, policyError("Policy Condition failed: " + c.Raw)
+		}
+	}
+
+	// Every field must be covered by a condition, other than those which
+	// S3 excludes.
+	for name := range fields {
+		switch {
+		case name == "policy", name == "x-amz-signature", strings.HasPrefix(name, "x-ignore-"):
+		case !covered[name]:
+			return nil, policyError("Extra input fields: " + name)
+		}
+	}
+
+	return conditions, nil
+}
+
+// postPrincipal returns r with the principal of a POST upload, which is the
+// access key its policy is signed with when s3.auth is used.
+func postPrincipal(r *http.Request, fields map[string]string) *http.Request {
+	creds, signed := r.Context().Value(credentialsKey{}).(credentials)
+	if !signed {
+		return r
+	}
+	if _, ok := fields["policy"]; !ok {
+		return r
+	}
+	auth := authorization{}
+	if err := auth.parseCredential(fields["x-amz-credential"]); err != nil {
+		return r
+	}
+	_, session, err := creds.secret(auth.AccessKeyID, fields["x-amz-security-token"])
+	if err != nil {
+		return r
+	}
+	return withPrincipal(r, principal{AccessKeyID: auth.AccessKeyID, Session: session})
+}
+
+// verifyPolicySignature verifies the x-amz-signature field is the SigV4
+// signature of the encoded policy.
+func verifyPolicySignature(creds credentials, encoded string, fields map[string]string) error {
+	if fields["x-amz-algorithm"] != amzAlgorithm {
+		return errUnsupportedAuthorization
+	}
+
+	auth := authorization{}
+	if err := auth.parseCredential(fields["x-amz-credential"]); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(fields["x-amz-date"], auth.Date) || auth.Service != awsService {
+		return errAuthorizationHeaderMalformed
+	}
+
+	secret, _, err := creds.secret(auth.AccessKeyID, fields["x-amz-security-token"])
+	if err != nil {
+		return err
+	}
+
+	signature := sign(encoded, secret, auth.Date, auth.Region, auth.Service)
+	if !hmac.Equal([]byte(signature), []byte(fields["x-amz-signature"])) {
+		err := errSignatureDoesNotMatch
+		err.AWSAccessKeyID = auth.AccessKeyID
+		err.StringToSign = encoded
+		return err
+	}
+	return nil
+}
+
+// parsePolicyCondition parses a condition of a policy document, either an
+// object with a single exact match or an array.
+func parsePolicyCondition(raw json.RawMessage) (policyCondition, error) {
+	c := policyCondition{Raw: string(raw)}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return c, errInvalidPolicyDocument
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) != 1 {
+			return c, errInvalidPolicyDocument
+		}
+		for field, value := range v {
+			c.Op, c.Field, c.Value = "eq", strings.ToLower(field), fmt.Sprint(value)
+		}
+		return c, nil
+	case []any:
+		if len(v) != 3 {
+			return c, errInvalidPolicyDocument
+		}
+		args := make([]string, len(v))
+		for i := range v {
+			args[i] = fmt.Sprint(v[i])
+		}
+		return c, c.parseArgs(args)
+	}
+	return c, errInvalidPolicyDocument
+}
+
+// parseArgs sets the condition from the elements of an array condition.
+func (c *policyCondition) parseArgs(args []string) error {
+	c.Op = strings.ToLower(args[0])
+	switch c.Op {
+	case "eq", "starts-with":
+		if !strings.HasPrefix(args[1], "$") {
+			return errInvalidPolicyDocument
+		}
+		c.Field = strings.ToLower(strings.TrimPrefix(args[1], "$"))
+		c.Value = args[2]
+	case "content-length-range":
+		var err1, err2 error
+		c.Min, err1 = strconv.ParseInt(args[1], 10, 64)
+		c.Max, err2 = strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil || c.Min < 0 || c.Max < c.Min {
+			return errInvalidPolicyDocument
+		}
+	default:
+		return errInvalidPolicyDocument
+	}
+	return nil
+}
+
+// matches reports whether value meets the condition.  The length of the
+// content is checked as it is read, by lengthRangeReader.
+func (c policyCondition) matches(value string) bool {
+	switch c.Op {
+	case "eq":
+		return value == c.Value
+	case "starts-with":
+		return strings.HasPrefix(value, c.Value)
+	}
+	return true
+}
+
+func policyError(message string) apiError {
+	err := errAccessDenied
+	err.Message = "Invalid according to Policy: " + message
+	return err
+}
+
+// lengthRangeReader fails reads which exceed max bytes, or reach EOF before
+// min bytes are read.
+type lengthRangeReader struct {
+	r    io.Reader
+	min  int64
+	max  int64
+	read int64
+}
+
+func (l *lengthRangeReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, errEntityTooLarge
+	}
+	if err == io.EOF && l.read < l.min {
+		return n, errEntityTooSmall
+	}
+	return n, err
+}
@@ -0,0 +1,280 @@
+package s3
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postObjectRequest returns a browser based upload of content to bucket,
+// with the fields preceding the file.
+func postObjectRequest(bucket, filename, content string, fields map[string]string) *http.Request {
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	// The key and policy fields are written first, as browsers send them in
+	// the order they appear in the form.
+	for _, name := range []string{"key", "policy"} {
+		if v, ok := fields[name]; ok {
+			mw.WriteField(name, v)
+		}
+	}
+	for name, v := range fields {
+		if name != "key" && name != "policy" {
+			mw.WriteField(name, v)
+		}
+	}
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write([]byte(content))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8000/"+bucket, &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestPostObject(t *testing.T) {
+	setTimeNow(t, time.Date(2013, 5, 24, 0, 30, 0, 0, time.UTC))
+	setTestCredentials(t)
+
+	signer := S3{
+		AccessID:   testAccessKeyID,
+		BucketName: "bucket",
+		AWSRegion:  "us-east-1",
+		AMZDate:    "20130524T000000Z",
+		Secret:     testSecret,
+		Host:       "localhost:8000",
+		Expires:    3600,
+	}
+
+	// form returns the signed fields of a policy with the given conditions,
+	// along with extra unsigned fields.
+	form := func(conditions []any, extra ...string) map[string]string {
+		fields, err := signer.SignPostPolicy(conditions...)
+		if err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			fields[extra[i]] = extra[i+1]
+		}
+		return fields
+	}
+	uploads := []any{[]any{"starts-with", "$key", "uploads/"}}
+
+	tests := map[string]struct {
+		fields   map[string]string
+		filename string
+		content  string
+		wantCode int
+		wantBody string
+		wantKey  string
+	}{
+		"valid": {
+			fields:   form(uploads, "key", "uploads/test.txt"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusNoContent,
+			wantKey:  "uploads/test.txt",
+		},
+		"filename": {
+			fields:   form(uploads, "key", "uploads/${filename}"),
+			filename: "photo.jpg",
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusNoContent,
+			wantKey:  "uploads/photo.jpg",
+		},
+		"status 201": {
+			fields: form(append(uploads, map[string]string{"success_action_status": "201"}),
+				"key", "uploads/test.txt", "success_action_status", "201"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusCreated,
+			wantBody: "<Key>uploads/test.txt</Key>",
+			wantKey:  "uploads/test.txt",
+		},
+		"redirect": {
+			fields: form(append(uploads, []any{"starts-with", "$success_action_redirect", "http://example.com/"}),
+				"key", "uploads/test.txt", "success_action_redirect", "http://example.com/done"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusSeeOther,
+			wantKey:  "uploads/test.txt",
+		},
+		"content type": {
+			fields: form(append(uploads, map[string]string{"Content-Type": "text/plain"}),
+				"key", "uploads/test.txt", "Content-Type", "text/plain"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusNoContent,
+			wantKey:  "uploads/test.txt",
+		},
+		"content type does not match": {
+			fields: form(append(uploads, map[string]string{"Content-Type": "text/plain"}),
+				"key", "uploads/test.txt", "Content-Type", "text/html"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusForbidden,
+			wantBody: "Policy Condition failed",
+		},
+		"key does not match": {
+			fields:   form(uploads, "key", "other/test.txt"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusForbidden,
+			wantBody: "Policy Condition failed",
+		},
+		"extra field": {
+			fields:   form(uploads, "key", "uploads/test.txt", "x-amz-meta-color", "red"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusForbidden,
+			wantBody: "Extra input fields: x-amz-meta-color",
+		},
+		"ignored field": {
+			fields:   form(uploads, "key", "uploads/test.txt", "x-ignore-submit", "Upload"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusNoContent,
+			wantKey:  "uploads/test.txt",
+		},
+		"too large": {
+			fields:   form(append(uploads, []any{"content-length-range", 1, 10}), "key", "uploads/test.txt"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>EntityTooLarge</Code>",
+		},
+		"too small": {
+			fields:   form(append(uploads, []any{"content-length-range", 100, 200}), "key", "uploads/test.txt"),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>EntityTooSmall</Code>",
+		},
+		"bad signature": {
+			fields:   form(uploads, "key", "uploads/test.txt", "x-amz-signature", strings.Repeat("0", 64)),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusForbidden,
+			wantBody: "<Code>SignatureDoesNotMatch</Code>",
+		},
+		"missing policy": {
+			fields:   map[string]string{"key": "uploads/test.txt"},
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusForbidden,
+			wantBody: "<Code>AccessDenied</Code>",
+		},
+		"missing key": {
+			fields:   form(uploads),
+			content:  "Welcome to Amazon S3.",
+			wantCode: http.StatusBadRequest,
+			wantBody: "<Code>InvalidArgument</Code>",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := httptest.NewRecorder()
+			Auth(h).ServeHTTP(res, postObjectRequest("bucket", tc.filename, tc.content, tc.fields))
+
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+			if tc.wantKey == "" {
+				return
+			}
+
+			got, err := os.ReadFile(filepath.Join(h.Directory, "bucket", filepath.FromSlash(tc.wantKey)))
+			if err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			if string(got) != tc.content {
+				t.Errorf("expected content to be %q got %q", tc.content, got)
+			}
+		})
+	}
+}
+
+func TestPostObjectExpired(t *testing.T) {
+	setTimeNow(t, time.Date(2013, 5, 24, 1, 0, 1, 0, time.UTC))
+	setTestCredentials(t)
+
+	signer := S3{AccessID: testAccessKeyID, BucketName: "bucket", AWSRegion: "us-east-1", AMZDate: "20130524T000000Z", Secret: testSecret, Expires: 3600}
+	fields, err := signer.SignPostPolicy([]any{"eq", "$key", "test.txt"})
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	fields["key"] = "test.txt"
+
+	h := newTestHandler(t, "bucket")
+	res := httptest.NewRecorder()
+	Auth(h).ServeHTTP(res, postObjectRequest("bucket", "", "data", fields))
+
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected code to be %d got %d", http.StatusForbidden, res.Code)
+	}
+	if want := "Policy expired."; !strings.Contains(res.Body.String(), want) {
+		t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+	}
+}
+
+func TestPostObjectUnsigned(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, postObjectRequest("bucket", "", "data", map[string]string{"key": "test.txt", "success_action_status": "200"}))
+
+	if res.Code != http.StatusOK {
+		t.Errorf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(h.Directory, "bucket", "test.txt")); err != nil {
+		t.Errorf("expected err to be nil got %v", err)
+	}
+}
+
+func TestPostObjectOtherBucket(t *testing.T) {
+	setTimeNow(t, time.Date(2013, 5, 24, 0, 30, 0, 0, time.UTC))
+	setTestCredentials(t)
+
+	signer := S3{AccessID: testAccessKeyID, BucketName: "bucket", AWSRegion: "us-east-1", AMZDate: "20130524T000000Z", Secret: testSecret, Expires: 3600}
+	fields, err := signer.SignPostPolicy([]any{"eq", "$key", "test.txt"})
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	fields["key"] = "test.txt"
+	fields["bucket"] = "bucket"
+
+	h := newTestHandler(t, "bucket", "other")
+	res := httptest.NewRecorder()
+	Auth(h).ServeHTTP(res, postObjectRequest("other", "", "data", fields))
+
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected code to be %d got %d", http.StatusForbidden, res.Code)
+	}
+	if want := "Policy Condition failed"; !strings.Contains(res.Body.String(), want) {
+		t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(h.Directory, "other", "test.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected err to be not exist got %v", err)
+	}
+}
+
+func TestPostObjectService(t *testing.T) {
+	setTimeNow(t, time.Date(2013, 5, 24, 0, 30, 0, 0, time.UTC))
+	setTestCredentials(t)
+
+	signer := S3{AccessID: testAccessKeyID, BucketName: "bucket", AWSRegion: "us-east-1", AMZDate: "20130524T000000Z", Secret: testSecret, Service: stsService, Expires: 3600}
+	fields, err := signer.SignPostPolicy([]any{"eq", "$key", "test.txt"})
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if want := testAccessKeyID + "/20130524/us-east-1/s3/aws4_request"; fields["x-amz-credential"] != want {
+		t.Errorf("expected credential to be %v got %v", want, fields["x-amz-credential"])
+	}
+	fields["key"] = "test.txt"
+
+	h := newTestHandler(t, "bucket")
+	res := httptest.NewRecorder()
+	Auth(h).ServeHTTP(res, postObjectRequest("bucket", "", "data", fields))
+
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected code to be %d got %d: %s", http.StatusNoContent, res.Code, res.Body.String())
+	}
+}
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,7 +22,7 @@ const amzAlgorithm string = "AWS4-HMAC-SHA256"
 const awsService string = "s3"
 const dateTimeLayout string = "20060102T150405Z"
 
-// S3 is responsible for signing and returning a presigned URL.
+// S3 is responsible for signing requests and returning presigned URLs.
 // For more details see: https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html.
 type S3 struct {
 	AccessID   string
@@ -25,79 +32,248 @@ type S3 struct {
 	Host       string
 	Secret     string
 	BucketName string
+	// Scheme of presigned URLs, https when empty.  The scheme is not signed,
+	// so http can be used for local endpoints.
+	Scheme string
+	// SessionToken is sent as X-Amz-Security-Token when signing with
+	// temporary credentials.
+	SessionToken string
+	// Service is the service requests are signed for, s3 when empty.
+	Service string
+	// Headers are signed in presigned URLs, and must be sent with the
+	// same values by the client using them.
+	Headers http.Header
+	// Query parameters are added to presigned URLs and signed, such as
+	// versionId or response-content-disposition.
+	Query url.Values
 }
 
 // Sign and return a URL for the configured environment and the requested object.
 func (s S3) Sign(method, objectName string) string {
-	var amzDate = s.AMZDate
-	if len(amzDate) == 0 {
-		amzDate = getDateTime()
+	amzDate := s.amzDate()
+
+	signedHeaders := []string{"host"}
+	for name := range s.Headers {
+		if name = strings.ToLower(name); name != "host" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	sort.Strings(signedHeaders)
+
+	query := url.Values{}
+	for name, values := range s.Query {
+		query[name] = values
+	}
+	query.Set("X-Amz-Algorithm", amzAlgorithm)
+	query.Set("X-Amz-Credential", s.AccessID+"/"+s.scope(amzDate))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(s.Expires))
+	query.Set("X-Amz-SignedHeaders", formatSignedHeaders(signedHeaders))
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	if len(s.BucketName) != 0 {
+		objectName = fmt.Sprintf("%s/%s", s.BucketName, objectName)
 	}
-	timeStamp := strings.Split(amzDate, "T")[0]
+	r := &http.Request{
+		Method: strings.ToUpper(method),
+		URL:    &url.URL{Path: "/" + objectName},
+		Host:   s.Host,
+		Header: s.Headers,
+	}
+
+	canonicalRequest := requestCanonicalRequest(r, canonicalQuery(query), signedHeaders, unsignedPayload)
+	signature := s.signature(amzDate, getStringToSign(amzDate, s.scope(amzDate), canonicalRequest))
 
-	amzCredential := strings.Join([]string{
-		s.AccessID,
-		timeStamp,
-		s.AWSRegion,
-		awsService,
-		"aws4_request",
-	}, "/")
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", s.scheme(), s.Host, canonicalURI(r), canonicalQuery(query), signature)
+}
 
-	signedHeaders := []string{
-		"host",
+// SignURL is Sign, returning an error rather than a URL no server accepts
+// when AMZDate is set but is not a valid date.
+func (s S3) SignURL(method, objectName string) (string, error) {
+	if err := s.validAMZDate(); err != nil {
+		return "", err
 	}
+	return s.Sign(method, objectName), nil
+}
 
-	var canonicalURI string
-	if len(s.BucketName) == 0 {
-		canonicalURI = fmt.Sprintf("https://%s/%s", s.Host, objectName)
-	} else {
-		canonicalURI = fmt.Sprintf("https://%s/%s/%s", s.Host, s.BucketName, objectName)
+// SignRequest signs r with an Authorization header.  X-Amz-Date and
+// X-Amz-Security-Token are set, along with X-Amz-Content-Sha256 for s3,
+// and every other header is signed other than those proxies and clients
+// commonly change.  Unless X-Amz-Content-Sha256 is already set, the body is
+// read to compute its hash and replaced.
+func (s S3) SignRequest(r *http.Request) error {
+	if err := s.validAMZDate(); err != nil {
+		return err
+	}
+	amzDate := s.amzDate()
+	if r.Host == "" {
+		r.Host = r.URL.Host
 	}
 
-	canonicalQueryString := [][]interface{}{
-		{"X-Amz-Algorithm", amzAlgorithm},
-		{"X-Amz-Credential", amzCredential},
-		{"X-Amz-Date", amzDate},
-		{"X-Amz-Expires", fmt.Sprintf("%d", s.Expires)},
-		{"X-Amz-SignedHeaders", formatSignedHeaders(signedHeaders)},
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		var err error
+		if payloadHash, err = hashBody(r); err != nil {
+			return err
+		}
+		if s.service() == awsService {
+			r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		}
 	}
 
-	canonicalHeaders := []string{
-		fmt.Sprintf("host:%s", s.Host),
+	r.Header.Del("Authorization")
+	r.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.SessionToken)
 	}
 
-	if len(s.BucketName) != 0 {
-		objectName = fmt.Sprintf("%s/%s", s.BucketName, objectName)
+	signedHeaders := []string{"host"}
+	for name := range r.Header {
+		if !slices.Contains(unsignedHeaders, name) {
+			signedHeaders = append(signedHeaders, strings.ToLower(name))
+		}
 	}
-	canonicalRequest := getCanonicalRequest(method, fmt.Sprintf("/%s", objectName), canonicalQueryString, canonicalHeaders, signedHeaders)
+	sort.Strings(signedHeaders)
 
-	crHash := sha256.Sum256([]byte(canonicalRequest))
-	stringToSign := strings.Join([]string{
-		amzAlgorithm,
-		amzDate,
-		strings.Join([]string{
-			timeStamp,
-			s.AWSRegion,
-			awsService,
-			"aws4_request",
-		}, "/"),
-		hex.EncodeToString([]byte(crHash[:])),
-	}, "\n")
+	// The path is sent as it is signed, and services other than s3 sign
+	// it encoded twice.
+	path := canonicalURI(r)
+	r.URL.RawPath = path
+	if s.service() != awsService {
+		path = uriEncode(path, false)
+	}
+	canonicalRequest := formatCanonicalRequest(r.Method, path, canonicalQuery(r.URL.Query()), canonicalHeaders(r, signedHeaders), signedHeaders, payloadHash)
+	signature := s.signature(amzDate, getStringToSign(amzDate, s.scope(amzDate), canonicalRequest))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		amzAlgorithm, s.AccessID, s.scope(amzDate), formatSignedHeaders(signedHeaders), signature))
+	return nil
+}
+
+// hashBody returns the hex encoded SHA256 of the body of r, replacing the
+// body so it can be read again.
+func hashBody(r *http.Request) (string, error) {
+	body := []byte{}
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// unsignedHeaders are not signed by SignRequest, as they are set or changed
+// by clients, proxies and the http package after signing.
+var unsignedHeaders = []string{
+	"Accept-Encoding",
+	"Authorization",
+	"Connection",
+	"Content-Length",
+	"Expect",
+	"Transfer-Encoding",
+	"User-Agent",
+	"X-Amzn-Trace-Id",
+	"X-Forwarded-For",
+}
+
+// amzDate returns the time requests are signed at, which is now unless
+// AMZDate is set.
+func (s S3) amzDate() string {
+	if len(s.AMZDate) == 0 {
+		return getDateTime()
+	}
+	return s.AMZDate
+}
+
+// validAMZDate returns an error when AMZDate is set but is not formatted as
+// 20060102T150405Z.
+func (s S3) validAMZDate() error {
+	if len(s.AMZDate) == 0 {
+		return nil
+	}
+	if _, err := time.Parse(dateTimeLayout, s.AMZDate); err != nil {
+		return fmt.Errorf("invalid AMZDate %q: %w", s.AMZDate, err)
+	}
+	return nil
+}
+
+// timeStamp returns the date of amzDate used in credential scopes.
+func timeStamp(amzDate string) string {
+	return strings.Split(amzDate, "T")[0]
+}
+
+// scope returns the credential scope of a request signed at amzDate.
+func (s S3) scope(amzDate string) string {
+	return strings.Join([]string{timeStamp(amzDate), s.AWSRegion, s.service(), "aws4_request"}, "/")
+}
+
+// signature returns the signature of stringToSign for a request signed at
+// amzDate.
+func (s S3) signature(amzDate, stringToSign string) string {
+	return sign(stringToSign, s.Secret, timeStamp(amzDate), s.AWSRegion, s.service())
+}
+
+func (s S3) scheme() string {
+	if s.Scheme == "" {
+		return "https"
+	}
+	return s.Scheme
+}
+
+func (s S3) service() string {
+	if s.Service == "" {
+		return awsService
+	}
+	return s.Service
+}
 
-	signature := sign(stringToSign, s.Secret, timeStamp, s.AWSRegion, awsService)
+// SignPostPolicy returns the form fields for a browser based POST upload to
+// the configured bucket, with a policy expiring after Expires seconds.
+// Conditions are added to the policy, each either a map of a field to its
+// value or a slice such as []any{"starts-with", "$key", "uploads/"}.
+// Policies are always signed for s3, regardless of Service.
+func (s S3) SignPostPolicy(conditions ...any) (map[string]string, error) {
+	if err := s.validAMZDate(); err != nil {
+		return nil, err
+	}
+	s.Service = awsService
+	amzDate := s.amzDate()
+	date, _ := time.Parse(dateTimeLayout, amzDate)
+	amzCredential := s.AccessID + "/" + s.scope(amzDate)
 
-	query := strings.Join([]string{
-		fmt.Sprintf("X-Amz-Algorithm=%s", amzAlgorithm),
-		fmt.Sprintf("X-Amz-Credential=%s", url.QueryEscape(amzCredential)),
-		fmt.Sprintf("X-Amz-Date=%s", amzDate),
-		fmt.Sprintf("X-Amz-Expires=%d", s.Expires),
-		fmt.Sprintf("X-Amz-SignedHeaders=%s", formatSignedHeaders(signedHeaders)),
-		fmt.Sprintf("%s=%s", "X-Amz-Signature", signature),
-	}, "&")
+	conditions = append([]any{
+		map[string]string{"bucket": s.BucketName},
+		map[string]string{"x-amz-algorithm": amzAlgorithm},
+		map[string]string{"x-amz-credential": amzCredential},
+		map[string]string{"x-amz-date": amzDate},
+	}, conditions...)
 
-	url := fmt.Sprintf("%s?%s", canonicalURI, query)
+	policy, err := json.Marshal(map[string]any{
+		"expiration": date.Add(time.Duration(s.Expires) * time.Second).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(policy)
 
-	return url
+	return map[string]string{
+		"policy":           encoded,
+		"x-amz-algorithm":  amzAlgorithm,
+		"x-amz-credential": amzCredential,
+		"x-amz-date":       amzDate,
+		"x-amz-signature":  s.signature(amzDate, encoded),
+	}, nil
 }
 
 func getDateTime() string {
@@ -113,25 +289,28 @@ func hmacSHA256(key []byte, msg []byte) []byte {
 }
 
 func sign(stringToSign, secret, amzDate, awsRegion, awsService string) string {
+	signature := hmacSHA256(getSigningKey(secret, amzDate, awsRegion, awsService), []byte(stringToSign)) // Hex(HMAC-SHA256(signingKey, stringToSign))
+	return hex.EncodeToString(signature)
+}
+
+func getSigningKey(secret, amzDate, awsRegion, awsService string) []byte {
 	dateKey := hmacSHA256([]byte(fmt.Sprintf("AWS4%s", secret)), []byte(amzDate)) // HMAC-SHA256("AWS4" + "<YourSecretAccessKey>","20130524")
 	dateRegionKey := hmacSHA256(dateKey, []byte(awsRegion))                       // HMAC-SHA256(dateKey, "us-east-1")
 	dateRegionServiceKey := hmacSHA256(dateRegionKey, []byte(awsService))         // HMAC-SHA256(dateRegionKey,"s3")
-	signingKey := hmacSHA256(dateRegionServiceKey, []byte("aws4_request"))        // HMAC-SHA256(dateRegionServiceKey, "aws4_request")
-	signature := hmacSHA256(signingKey, []byte(stringToSign))                     // Hex(HMAC-SHA256(signingKey, stringToSign))
-	return hex.EncodeToString(signature)
+	return hmacSHA256(dateRegionServiceKey, []byte("aws4_request"))               // HMAC-SHA256(dateRegionServiceKey, "aws4_request")
 }
 
+// formatCanonicalQueryString returns the canonical query string of query,
+// a list of key and value pairs.  Entries which are not pairs are ignored.
 func formatCanonicalQueryString(query [][]interface{}) string {
-	params := []string{}
+	values := url.Values{}
 	for _, v := range query {
 		if len(v) != 2 {
 			continue
 		}
-		key := fmt.Sprintf("%v", v[0])
-		value := fmt.Sprintf("%v", v[1])
-		params = append(params, fmt.Sprintf("%s=%s", url.QueryEscape(key), url.QueryEscape(value)))
+		values.Add(fmt.Sprintf("%v", v[0]), fmt.Sprintf("%v", v[1]))
 	}
-	return strings.Join(params, "&")
+	return canonicalQuery(values)
 }
 
 func formatHeaders(headers []string) string {
@@ -147,12 +326,105 @@ func formatSignedHeaders(signedHeaders []string) string {
 }
 
 func getCanonicalRequest(method string, path string, query [][]interface{}, headers []string, signedHeaders []string) string {
+	return formatCanonicalRequest(strings.ToUpper(method), path, formatCanonicalQueryString(query), formatHeaders(headers), signedHeaders, unsignedPayload)
+}
+
+// formatCanonicalRequest joins the parts of a canonical request, each of
+// which is already in its canonical form other than signedHeaders.
+func formatCanonicalRequest(method, path, query, headers string, signedHeaders []string, payloadHash string) string {
 	return strings.Join([]string{
-		strings.ToUpper(method),
+		method,
 		path,
-		formatCanonicalQueryString(query),
-		formatHeaders(headers),
+		query,
+		headers,
 		formatSignedHeaders(signedHeaders),
-		"UNSIGNED-PAYLOAD",
+		payloadHash,
+	}, "\n")
+}
+
+// uriEncode encodes s as described by the SigV4 specification, leaving only
+// unreserved characters, and optionally slashes, unescaped.
+func uriEncode(s string, encodeSlash bool) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-' || c == '_' || c == '.' || c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// canonicalURI returns the encoded path of a request.
+func canonicalURI(r *http.Request) string {
+	if r.URL.Path == "" {
+		return "/"
+	}
+	return uriEncode(r.URL.Path, false)
+}
+
+// canonicalQuery returns the sorted and encoded query string of a request,
+// omitting the named parameters.
+func canonicalQuery(query url.Values, omit ...string) string {
+	params := []string{}
+	for key, values := range query {
+		if slices.Contains(omit, key) {
+			continue
+		}
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// canonicalHeaders returns the canonical form of the signed headers of a
+// request, each on its own line.
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	buf := strings.Builder{}
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values(name)
+			if len(values) == 0 && r.ContentLength >= 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		default:
+			values = r.Header.Values(name)
+		}
+
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		buf.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	return buf.String()
+}
+
+// requestCanonicalRequest rebuilds the canonical request for an incoming
+// request as it would have been computed by the client which signed it.
+func requestCanonicalRequest(r *http.Request, query string, signedHeaders []string, payloadHash string) string {
+	return formatCanonicalRequest(r.Method, canonicalURI(r), query, canonicalHeaders(r, signedHeaders), signedHeaders, payloadHash)
+}
+
+func getStringToSign(amzDate, scope, canonicalRequest string) string {
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		amzAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(crHash[:]),
 	}, "\n")
 }
@@ -0,0 +1,375 @@
+package s3
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const sseAlgorithm = "AES256"
+
+// encryptionSegmentSize is the amount of data sealed at a time, so ranges of
+// an encrypted object can be read without decrypting all of it.
+const encryptionSegmentSize = 64 * 1024
+
+// encryptionNonceSize is the size of the random prefix stored at the start
+// of an encrypted file.  The nonce of each segment is the prefix followed by
+// the segment's index.
+const encryptionNonceSize = 8
+
+// sseCustomerHeaders and sseCopySourceHeaders prefix the headers carrying
+// the customer key of the object written and of the source of a copy.
+const sseCustomerHeaders = "X-Amz-Server-Side-Encryption-Customer-"
+const sseCopySourceHeaders = "X-Amz-Copy-Source-Server-Side-Encryption-Customer-"
+
+// objectEncryption describes how an object is encrypted at rest.  Each
+// object has its own data key, which is stored encrypted with either the
+// customer's key, for SSE-C, or the Handler's master key, for SSE-S3.
+type objectEncryption struct {
+	Algorithm string `json:"algorithm"`
+	// CustomerKeyMD5 is the base64 encoded MD5 of the customer key of SSE-C
+	// objects, and empty for SSE-S3.
+	CustomerKeyMD5 string `json:"customerKeyMD5,omitempty"`
+	// Key is the base64 encoded, encrypted, data key.
+	Key string `json:"key"`
+}
+
+// customerKey is an SSE-C key sent with a request.
+type customerKey struct {
+	Key []byte
+	MD5 string
+}
+
+// parseCustomerKey returns the SSE-C key in the headers beginning with
+// prefix, or nil if there is none.
+func parseCustomerKey(header http.Header, prefix string) (*customerKey, error) {
+	algorithm := header.Get(prefix + "Algorithm")
+	encoded := header.Get(prefix + "Key")
+	sum := header.Get(prefix + "Key-Md5")
+	if algorithm == "" && encoded == "" && sum == "" {
+		return nil, nil
+	}
+
+	switch {
+	case algorithm == "":
+		return nil, errMissingSSECustomerAlgorithm
+	case algorithm != sseAlgorithm:
+		return nil, errInvalidEncryptionAlgorithm
+	case encoded == "":
+		return nil, errMissingSSECustomerKey
+	case sum == "":
+		return nil, errMissingSSECustomerKeyMD5
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errInvalidSSECustomerKey
+	}
+	if keyMD5(key) != sum {
+		return nil, errSSECustomerKeyMD5Mismatch
+	}
+	return &customerKey{Key: key, MD5: sum}, nil
+}
+
+// newEncryption returns the encryption requested by the
+// x-amz-server-side-encryption and SSE-C headers of a request, along with
+// a new data key, or nil if the object should not be encrypted.
+func (h Handler) newEncryption(header http.Header) (*objectEncryption, []byte, error) {
+	sse := header.Get("X-Amz-Server-Side-Encryption")
+	if sse != "" && sse != sseAlgorithm {
+		return nil, nil, errInvalidEncryptionMethod
+	}
+	customer, err := parseCustomerKey(header, sseCustomerHeaders)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sse != "" && customer != nil {
+		return nil, nil, errIncompatibleEncryption
+	}
+	if sse == "" && customer == nil {
+		return nil, nil, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	enc := &objectEncryption{Algorithm: sseAlgorithm}
+	wrappingKey := []byte(nil)
+	if customer != nil {
+		enc.CustomerKeyMD5 = customer.MD5
+		wrappingKey = customer.Key
+	} else if wrappingKey, err = h.masterKey(); err != nil {
+		return nil, nil, err
+	}
+	if enc.Key, err = sealKey(wrappingKey, dataKey); err != nil {
+		return nil, nil, err
+	}
+	return enc, dataKey, nil
+}
+
+// dataKey returns the key an object's data is encrypted with, or nil if it
+// is not encrypted.  The SSE-C key of the object must be sent in the
+// headers beginning with prefix.
+func (h Handler) dataKey(meta objectMeta, header http.Header, prefix string) ([]byte, error) {
+	customer, err := parseCustomerKey(header, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := meta.Encryption
+	switch {
+	case enc == nil || enc.CustomerKeyMD5 == "":
+		if customer != nil {
+			return nil, errSSENotApplicable
+		}
+		if enc == nil {
+			return nil, nil
+		}
+		master, err := h.masterKey()
+		if err != nil {
+			return nil, err
+		}
+		return openKey(master, enc.Key)
+	case customer == nil:
+		return nil, errSSECustomerKeyRequired
+	case customer.MD5 != enc.CustomerKeyMD5:
+		return nil, errAccessDenied
+	}
+	return openKey(customer.Key, enc.Key)
+}
+
+// masterKey returns the key SSE-S3 data keys are encrypted with, creating
+// it the first time it is used.
+func (h Handler) masterKey() ([]byte, error) {
+	name := h.systemPath("sse.key")
+	key, err := os.ReadFile(name)
+	if err == nil || !isNotExist(err) {
+		return key, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another request created the key first.
+		return os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return key, err
+}
+
+// writeEncryptionHeaders sets the headers S3 returns for an object encrypted
+// with enc.
+func writeEncryptionHeaders(header http.Header, enc *objectEncryption) {
+	switch {
+	case enc == nil:
+	case enc.CustomerKeyMD5 != "":
+		header.Set(sseCustomerHeaders+"Algorithm", enc.Algorithm)
+		header.Set(sseCustomerHeaders+"Key-Md5", enc.CustomerKeyMD5)
+	default:
+		header.Set("X-Amz-Server-Side-Encryption", enc.Algorithm)
+	}
+}
+
+func keyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealKey encrypts a data key with key, returning it base64 encoded.
+func sealKey(key, dataKey []byte) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// openKey decrypts a data key sealed by sealKey.
+func openKey(key []byte, sealed string) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("s3: invalid data key")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// decryptedSize returns the size of the data held by an encrypted file of
+// size bytes.
+func decryptedSize(size int64) int64 {
+	size -= encryptionNonceSize
+	segments := (size + encryptionSegmentSize + 15) / (encryptionSegmentSize + 16)
+	return max(size-segments*16, 0)
+}
+
+// segmentNonce returns the nonce of a segment of an encrypted file.
+func segmentNonce(prefix []byte, index int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	for i := 0; i < 4; i++ {
+		nonce[11-i] = byte(index >> (8 * i))
+	}
+	return nonce
+}
+
+// segmentData is the additional data sealed with each segment, which marks
+// the final segment so truncated files are detected.
+func segmentData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptWriter encrypts the data written to it in segments of
+// encryptionSegmentSize, each sealed with AES-256-GCM.  Close must be
+// called to write the final segment, which is empty when no data was
+// written, so that empty objects are authenticated too.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	index  int64
+	err    error
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptionSegmentSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && e.err == nil {
+		// A full segment is only sealed once more data arrives, as the
+		// last segment must be sealed as final.
+		if len(e.buf) == encryptionSegmentSize {
+			e.seal(false)
+			continue
+		}
+		c := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, e.err
+}
+
+func (e *encryptWriter) Close() error {
+	if e.err == nil {
+		e.seal(true)
+	}
+	return e.err
+}
+
+func (e *encryptWriter) seal(final bool) {
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.index), e.buf, segmentData(final))
+	_, e.err = e.w.Write(sealed)
+	e.buf = e.buf[:0]
+	e.index++
+}
+
+// decryptReader reads the data of an encrypted file, starting at an offset
+// into the decrypted data.
+type decryptReader struct {
+	r        io.ReaderAt
+	aead     cipher.AEAD
+	prefix   []byte
+	segments int64
+	index    int64
+	skip     int
+	buf      []byte
+}
+
+// newDecryptReader returns a reader of the data held by the encrypted file
+// r, of size bytes, from offset.
+func newDecryptReader(r io.ReaderAt, size int64, key []byte, offset int64) (*decryptReader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionNonceSize)
+	if _, err := r.ReadAt(prefix, 0); err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:        r,
+		aead:     aead,
+		prefix:   prefix,
+		segments: (size - encryptionNonceSize + encryptionSegmentSize + 15) / (encryptionSegmentSize + 16),
+		index:    offset / encryptionSegmentSize,
+		skip:     int(offset % encryptionSegmentSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.index >= d.segments {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, encryptionSegmentSize+16)
+		n, err := d.r.ReadAt(sealed, encryptionNonceSize+d.index*int64(len(sealed)))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		final := d.index == d.segments-1
+		d.buf, err = d.aead.Open(sealed[:0], segmentNonce(d.prefix, d.index), sealed[:n], segmentData(final))
+		if err != nil {
+			return 0, errors.New("s3: encrypted object has been modified")
+		}
+		d.buf = d.buf[min(d.skip, len(d.buf)):]
+		d.skip = 0
+		d.index++
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
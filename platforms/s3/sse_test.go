@@ -0,0 +1,333 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// customerKeyHeaders returns the SSE-C headers for a key made of b, with the
+// given header prefix.
+func customerKeyHeaders(prefix string, b byte) []string {
+	key := bytes.Repeat([]byte{b}, 32)
+	sum := md5.Sum(key)
+	return []string{
+		prefix + "Algorithm", sseAlgorithm,
+		prefix + "Key", base64.StdEncoding.EncodeToString(key),
+		prefix + "Key-Md5", base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+func TestSSES3(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodPut, "/bucket/secret.txt", "plaintext", "X-Amz-Server-Side-Encryption", "AES256")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+		t.Errorf("expected encryption header to be %q got %q", "AES256", got)
+	}
+	// The ETag is the MD5 of the plaintext, whatever is stored.
+	if sum := md5.Sum([]byte("plaintext")); res.Header().Get("ETag") != quoteETag(hex.EncodeToString(sum[:])) {
+		t.Errorf("expected ETag to be the MD5 of the plaintext got %q", res.Header().Get("ETag"))
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.Directory, "bucket", "secret.txt"))
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if bytes.Contains(data, []byte("plaintext")) {
+		t.Errorf("expected object to be encrypted at rest got %q", data)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/secret.txt", "")
+	if res.Code != http.StatusOK || res.Body.String() != "plaintext" {
+		t.Errorf("expected plaintext got %d: %q", res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+		t.Errorf("expected encryption header to be %q got %q", "AES256", got)
+	}
+	if got := res.Header().Get("Content-Length"); got != "9" {
+		t.Errorf("expected Content-Length to be %q got %q", "9", got)
+	}
+
+	res = doRequest(h, http.MethodHead, "/bucket/secret.txt", "")
+	if got := res.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+		t.Errorf("expected HEAD encryption header to be %q got %q", "AES256", got)
+	}
+
+	result, _, _ := listKeys(t, h, "/bucket?list-type=2")
+	if len(result.Contents) != 1 || result.Contents[0].Size != 9 {
+		t.Errorf("expected listed size to be 9 got %+v", result.Contents)
+	}
+}
+
+func TestSSEC(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	key := customerKeyHeaders(sseCustomerHeaders, 'a')
+
+	res := doRequest(h, http.MethodPut, "/bucket/secret.txt", "plaintext", key...)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get(sseCustomerHeaders + "Key-Md5"); got != key[5] {
+		t.Errorf("expected key MD5 header to be %q got %q", key[5], got)
+	}
+	doRequest(h, http.MethodPut, "/bucket/plain.txt", "plaintext")
+
+	mismatch := customerKeyHeaders(sseCustomerHeaders, 'a')
+	mismatch[5] = customerKeyHeaders(sseCustomerHeaders, 'b')[5]
+
+	tests := map[string]struct {
+		method   string
+		target   string
+		headers  []string
+		wantCode int
+		wantBody string
+	}{
+		"get":                {method: http.MethodGet, target: "/bucket/secret.txt", headers: key, wantCode: http.StatusOK, wantBody: "plaintext"},
+		"head":               {method: http.MethodHead, target: "/bucket/secret.txt", headers: key, wantCode: http.StatusOK},
+		"get without key":    {method: http.MethodGet, target: "/bucket/secret.txt", wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidRequest</Code>"},
+		"head without key":   {method: http.MethodHead, target: "/bucket/secret.txt", wantCode: http.StatusBadRequest},
+		"wrong key":          {method: http.MethodGet, target: "/bucket/secret.txt", headers: customerKeyHeaders(sseCustomerHeaders, 'b'), wantCode: http.StatusForbidden, wantBody: "<Code>AccessDenied</Code>"},
+		"key MD5 mismatch":   {method: http.MethodGet, target: "/bucket/secret.txt", headers: mismatch, wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidArgument</Code>"},
+		"key on unencrypted": {method: http.MethodGet, target: "/bucket/plain.txt", headers: key, wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidRequest</Code>"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, tc.method, tc.target, "", tc.headers...)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestPutObjectEncryptionInvalid(t *testing.T) {
+	tests := map[string]struct {
+		headers  []string
+		wantBody string
+	}{
+		"method":        {headers: []string{"X-Amz-Server-Side-Encryption", "aws:kms"}, wantBody: "<Code>InvalidArgument</Code>"},
+		"both":          {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), "X-Amz-Server-Side-Encryption", "AES256"), wantBody: "<Code>InvalidArgument</Code>"},
+		"algorithm":     {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), sseCustomerHeaders+"Algorithm", "DES"), wantBody: "<Code>InvalidEncryptionAlgorithmError</Code>"},
+		"no key":        {headers: []string{sseCustomerHeaders + "Algorithm", "AES256"}, wantBody: "<Code>InvalidArgument</Code>"},
+		"short key":     {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), sseCustomerHeaders+"Key", "c2hvcnQ="), wantBody: "<Code>InvalidArgument</Code>"},
+		"no key MD5":    {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), sseCustomerHeaders+"Key-Md5", ""), wantBody: "<Code>InvalidArgument</Code>"},
+		"no algorithm":  {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), sseCustomerHeaders+"Algorithm", ""), wantBody: "<Code>InvalidArgument</Code>"},
+		"wrong key MD5": {headers: append(customerKeyHeaders(sseCustomerHeaders, 'a'), sseCustomerHeaders+"Key-Md5", customerKeyHeaders(sseCustomerHeaders, 'b')[5]), wantBody: "<Code>InvalidArgument</Code>"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			res := doRequest(h, http.MethodPut, "/bucket/secret.txt", "plaintext", tc.headers...)
+			if res.Code != http.StatusBadRequest {
+				t.Errorf("expected code to be %d got %d: %s", http.StatusBadRequest, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestSSERange(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	data := make([]byte, 3*encryptionSegmentSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	doRequest(h, http.MethodPut, "/bucket/large.bin", string(data), "X-Amz-Server-Side-Encryption", "AES256")
+
+	tests := map[string]struct {
+		rng   string
+		start int
+		end   int
+	}{
+		"first byte":     {rng: "bytes=0-0", start: 0, end: 1},
+		"across segment": {rng: "bytes=65530-65545", start: 65530, end: 65546},
+		"segment start":  {rng: "bytes=131072-131079", start: 131072, end: 131080},
+		"suffix":         {rng: "bytes=-150", start: len(data) - 150, end: len(data)},
+		"open ended":     {rng: "bytes=196000-", start: 196000, end: len(data)},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, http.MethodGet, "/bucket/large.bin", "", "Range", tc.rng)
+			if res.Code != http.StatusPartialContent {
+				t.Fatalf("expected code to be %d got %d", http.StatusPartialContent, res.Code)
+			}
+			if !bytes.Equal(res.Body.Bytes(), data[tc.start:tc.end]) {
+				t.Errorf("expected range %q to be %d bytes from %d got %d bytes", tc.rng, tc.end-tc.start, tc.start, res.Body.Len())
+			}
+		})
+	}
+
+	res := doRequest(h, http.MethodGet, "/bucket/large.bin", "")
+	if !bytes.Equal(res.Body.Bytes(), data) {
+		t.Errorf("expected object to be %d bytes got %d", len(data), res.Body.Len())
+	}
+}
+
+func TestSSEModified(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket/secret.txt", "plaintext", "X-Amz-Server-Side-Encryption", "AES256")
+
+	name := filepath.Join(h.Directory, "bucket", "secret.txt")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	res := doRequest(h, http.MethodGet, "/bucket/secret.txt", "")
+	if strings.Contains(res.Body.String(), "plaintext") {
+		t.Errorf("expected modified object not to be returned got %q", res.Body.String())
+	}
+}
+
+func TestSSECopy(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	source := customerKeyHeaders(sseCustomerHeaders, 'a')
+	doRequest(h, http.MethodPut, "/bucket/source.txt", "plaintext", source...)
+
+	res := doRequest(h, http.MethodPut, "/bucket/copy.txt", "", "X-Amz-Copy-Source", "/bucket/source.txt")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected copy without the source key to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+
+	headers := append(customerKeyHeaders(sseCopySourceHeaders, 'a'), "X-Amz-Copy-Source", "/bucket/source.txt", "X-Amz-Server-Side-Encryption", "AES256")
+	res = doRequest(h, http.MethodPut, "/bucket/copy.txt", "", headers...)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+		t.Errorf("expected encryption header to be %q got %q", "AES256", got)
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket/copy.txt", ""); res.Body.String() != "plaintext" {
+		t.Errorf("expected copy to be %q got %q", "plaintext", res.Body.String())
+	}
+
+	// Objects can be copied onto themselves to change their encryption.
+	headers = append(customerKeyHeaders(sseCopySourceHeaders, 'a'), customerKeyHeaders(sseCustomerHeaders, 'b')...)
+	headers = append(headers, "X-Amz-Copy-Source", "/bucket/source.txt")
+	if res := doRequest(h, http.MethodPut, "/bucket/source.txt", "", headers...); res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket/source.txt", "", customerKeyHeaders(sseCustomerHeaders, 'a')...); res.Code != http.StatusForbidden {
+		t.Errorf("expected old key to be %d got %d", http.StatusForbidden, res.Code)
+	}
+	if res := doRequest(h, http.MethodGet, "/bucket/source.txt", "", customerKeyHeaders(sseCustomerHeaders, 'b')...); res.Body.String() != "plaintext" {
+		t.Errorf("expected object to be %q got %q", "plaintext", res.Body.String())
+	}
+}
+
+func TestSSEMultipartUpload(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	key := customerKeyHeaders(sseCustomerHeaders, 'a')
+	target := "/bucket/large.bin"
+
+	res := doRequest(h, http.MethodPost, target+"?uploads", "", key...)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected create status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get(sseCustomerHeaders + "Algorithm"); got != sseAlgorithm {
+		t.Errorf("expected algorithm header to be %q got %q", sseAlgorithm, got)
+	}
+	result := initiateMultipartUploadResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	part := target + "?uploadId=" + result.UploadID + "&partNumber="
+
+	if res := doRequest(h, http.MethodPut, part+"1", "data"); res.Code != http.StatusBadRequest {
+		t.Errorf("expected part without key to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+
+	first := strings.Repeat("a", minPartSize)
+	res1 := doRequest(h, http.MethodPut, part+"1", first, key...)
+	res2 := doRequest(h, http.MethodPut, part+"2", "tail", key...)
+	if res1.Code != http.StatusOK || res2.Code != http.StatusOK {
+		t.Fatalf("expected part status to be %d got %d and %d", http.StatusOK, res1.Code, res2.Code)
+	}
+
+	res = doRequest(h, http.MethodGet, target+"?uploadId="+result.UploadID, "")
+	parts := listPartsResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &parts); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if len(parts.Parts) != 2 || parts.Parts[0].Size != minPartSize || parts.Parts[1].Size != 4 {
+		t.Errorf("expected part sizes to be %d and 4 got %+v", minPartSize, parts.Parts)
+	}
+
+	res = doRequest(h, http.MethodPost, target+"?uploadId="+result.UploadID, completeBody(res1.Header().Get("ETag"), res2.Header().Get("ETag")))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected complete status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	if res := doRequest(h, http.MethodGet, target, ""); res.Code != http.StatusBadRequest {
+		t.Errorf("expected get without key to be %d got %d", http.StatusBadRequest, res.Code)
+	}
+	res = doRequest(h, http.MethodGet, target, "", key...)
+	if res.Body.String() != first+"tail" {
+		t.Errorf("expected object to be %d bytes got %d", len(first)+4, res.Body.Len())
+	}
+	if got := res.Header().Get("Content-Length"); got != strconv.Itoa(len(first)+4) {
+		t.Errorf("expected Content-Length to be %d got %s", len(first)+4, got)
+	}
+}
+
+func TestEncryptWriter(t *testing.T) {
+	key := bytes.Repeat([]byte{'k'}, 32)
+	for _, size := range []int{0, 1, encryptionSegmentSize - 1, encryptionSegmentSize, encryptionSegmentSize + 1, 2 * encryptionSegmentSize} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			data := bytes.Repeat([]byte{'d'}, size)
+			buf := bytes.Buffer{}
+			if err := copyData(&buf, bytes.NewReader(data), key); err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			if got := decryptedSize(int64(buf.Len())); got != int64(size) {
+				t.Errorf("expected decrypted size to be %d got %d", size, got)
+			}
+
+			d, err := newDecryptReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), key, 0)
+			if err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			got, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatalf("expected err to be nil got %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("expected %d bytes got %d", size, len(got))
+			}
+
+			// Dropping the final segment must be detected.
+			if size > encryptionSegmentSize {
+				truncated := buf.Bytes()[:encryptionNonceSize+encryptionSegmentSize+16]
+				d, _ := newDecryptReader(bytes.NewReader(truncated), int64(len(truncated)), key, 0)
+				if _, err := io.ReadAll(d); err == nil {
+					t.Errorf("expected truncated data to return an error")
+				}
+			}
+		})
+	}
+}
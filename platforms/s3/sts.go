@@ -0,0 +1,391 @@
+package s3
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stsService = "sts"
+const stsXmlns = "https://sts.amazonaws.com/doc/2011-06-15/"
+
+const defaultAssumeRoleDuration = time.Hour
+const maxAssumeRoleDuration = 12 * time.Hour
+const defaultSessionTokenDuration = 12 * time.Hour
+const maxSessionTokenDuration = 36 * time.Hour
+const minSessionDuration = 15 * time.Minute
+
+// maxRoleChainingDuration is the longest credentials issued by AssumeRole
+// may last when it is called with temporary credentials.
+const maxRoleChainingDuration = time.Hour
+
+var roleARNPattern = regexp.MustCompile(`^arn:aws:iam::[0-9]{12}:role/([\w+=,.@/-]{1,64})$`)
+var roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// session holds the temporary credentials issued by STS.  Sessions are not
+// stored, they are sealed into their session token with the secret of the
+// access key they were issued to, so they can be verified by s3.auth and
+// are revoked when that access key is removed.
+type session struct {
+	AccessKeyID string    `json:"accessKeyId"`
+	Secret      string    `json:"secret"`
+	Expiration  time.Time `json:"expiration"`
+	// Parent is the access key the credentials were issued to.
+	Parent string `json:"parent"`
+	// RoleARN and SessionName are set for credentials issued by AssumeRole.
+	RoleARN     string `json:"roleArn,omitempty"`
+	SessionName string `json:"sessionName,omitempty"`
+}
+
+type stsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type assumedRoleUser struct {
+	AssumedRoleID string `xml:"AssumedRoleId"`
+	Arn           string `xml:"Arn"`
+}
+
+type responseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+type assumeRoleResponse struct {
+	XMLName          xml.Name         `xml:"AssumeRoleResponse"`
+	Xmlns            string           `xml:"xmlns,attr"`
+	Result           assumeRoleResult `xml:"AssumeRoleResult"`
+	ResponseMetadata responseMetadata `xml:"ResponseMetadata"`
+}
+
+type assumeRoleResult struct {
+	Credentials     stsCredentials  `xml:"Credentials"`
+	AssumedRoleUser assumedRoleUser `xml:"AssumedRoleUser"`
+}
+
+type getSessionTokenResponse struct {
+	XMLName          xml.Name              `xml:"GetSessionTokenResponse"`
+	Xmlns            string                `xml:"xmlns,attr"`
+	Result           getSessionTokenResult `xml:"GetSessionTokenResult"`
+	ResponseMetadata responseMetadata      `xml:"ResponseMetadata"`
+}
+
+type getSessionTokenResult struct {
+	Credentials stsCredentials `xml:"Credentials"`
+}
+
+type getCallerIdentityResponse struct {
+	XMLName          xml.Name                `xml:"GetCallerIdentityResponse"`
+	Xmlns            string                  `xml:"xmlns,attr"`
+	Result           getCallerIdentityResult `xml:"GetCallerIdentityResult"`
+	ResponseMetadata responseMetadata        `xml:"ResponseMetadata"`
+}
+
+type getCallerIdentityResult struct {
+	Arn     string `xml:"Arn"`
+	UserID  string `xml:"UserId"`
+	Account string `xml:"Account"`
+}
+
+type stsErrorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Error     stsError `xml:"Error"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type stsError struct {
+	Type    string `xml:"Type"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// isSTSRequest reports whether r is a call to the STS query API, which is
+// a form POSTed to the root of the service.  Requests to the root of a
+// bucket addressed by a subdomain of domain are not.
+func isSTSRequest(r *http.Request, domain string) bool {
+	if _, ok := virtualHostBucket(domain, r.Host); ok {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && (r.URL.Path == "/" || r.URL.Path == "") &&
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+// requestService returns the service a request must be signed for.
+func requestService(r *http.Request, domain string) string {
+	if isSTSRequest(r, domain) {
+		return stsService
+	}
+	return awsService
+}
+
+func (h Handler) serveSTS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeSTSError(w, errInvalidRequest)
+		return
+	}
+
+	p, _ := requestPrincipal(r)
+	creds, ok := r.Context().Value(credentialsKey{}).(credentials)
+	if !ok || p.AccessKeyID == "" {
+		writeSTSError(w, errMissingAuthenticationToken)
+		return
+	}
+
+	action := r.Form.Get("Action")
+	switch action {
+	case "AssumeRole":
+		assumeRole(w, r, creds, p)
+	case "GetSessionToken":
+		getSessionToken(w, r, creds, p)
+	case "GetCallerIdentity":
+		writeXML(w, http.StatusOK, getCallerIdentityResponse{
+			Xmlns:            stsXmlns,
+			Result:           getCallerIdentityResult{Arn: p.arn(), UserID: p.userID(), Account: accountID},
+			ResponseMetadata: responseMetadata{RequestID: w.Header().Get("X-Amz-Request-Id")},
+		})
+	default:
+		err := errInvalidAction
+		err.Message = "Could not find operation " + action + " for version 2011-06-15"
+		writeSTSError(w, err)
+	}
+}
+
+func assumeRole(w http.ResponseWriter, r *http.Request, creds credentials, p principal) {
+	roleARN := r.Form.Get("RoleArn")
+	sessionName := r.Form.Get("RoleSessionName")
+	if !roleARNPattern.MatchString(roleARN) {
+		writeSTSError(w, validationError("RoleArn", roleARN))
+		return
+	}
+	if !roleSessionNamePattern.MatchString(sessionName) {
+		writeSTSError(w, validationError("RoleSessionName", sessionName))
+		return
+	}
+
+	maxDuration := maxAssumeRoleDuration
+	if p.Session != nil {
+		maxDuration = maxRoleChainingDuration
+	}
+	duration, err := parseSessionDuration(r.Form.Get("DurationSeconds"), defaultAssumeRoleDuration, maxDuration)
+	if err != nil {
+		writeSTSError(w, err)
+		return
+	}
+
+	s, token, err := creds.issue(p.user(), duration, roleARN, sessionName)
+	if err != nil {
+		writeSTSError(w, errInternalError)
+		return
+	}
+	sp := principal{AccessKeyID: s.AccessKeyID, Session: &s}
+	writeXML(w, http.StatusOK, assumeRoleResponse{
+		Xmlns: stsXmlns,
+		Result: assumeRoleResult{
+			Credentials:     s.credentials(token),
+			AssumedRoleUser: assumedRoleUser{AssumedRoleID: sp.userID(), Arn: sp.arn()},
+		},
+		ResponseMetadata: responseMetadata{RequestID: w.Header().Get("X-Amz-Request-Id")},
+	})
+}
+
+func getSessionToken(w http.ResponseWriter, r *http.Request, creds credentials, p principal) {
+	if p.Session != nil {
+		err := errAccessDenied
+		err.Message = "Cannot call GetSessionToken with session credentials"
+		writeSTSError(w, err)
+		return
+	}
+
+	duration, err := parseSessionDuration(r.Form.Get("DurationSeconds"), defaultSessionTokenDuration, maxSessionTokenDuration)
+	if err != nil {
+		writeSTSError(w, err)
+		return
+	}
+
+	s, token, err := creds.issue(p.AccessKeyID, duration, "", "")
+	if err != nil {
+		writeSTSError(w, errInternalError)
+		return
+	}
+	writeXML(w, http.StatusOK, getSessionTokenResponse{
+		Xmlns:            stsXmlns,
+		Result:           getSessionTokenResult{Credentials: s.credentials(token)},
+		ResponseMetadata: responseMetadata{RequestID: w.Header().Get("X-Amz-Request-Id")},
+	})
+}
+
+// parseSessionDuration parses the DurationSeconds parameter, which must be
+// between minSessionDuration and maxDuration.
+func parseSessionDuration(value string, defaultDuration, maxDuration time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultDuration, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	duration := time.Duration(seconds) * time.Second
+	if err != nil || duration < minSessionDuration || duration > maxDuration {
+		err := validationError("DurationSeconds", value)
+		err.Message += "; Member must have value between " + strconv.Itoa(int(minSessionDuration.Seconds())) +
+			" and " + strconv.Itoa(int(maxDuration.Seconds()))
+		return 0, err
+	}
+	return duration, nil
+}
+
+// issue returns new temporary credentials for the access key parent, which
+// expire after duration, along with their session token.
+func (c credentials) issue(parent string, duration time.Duration, roleARN, sessionName string) (session, string, error) {
+	id := make([]byte, 10)
+	secret := make([]byte, 30)
+	if _, err := rand.Read(id); err != nil {
+		return session{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return session{}, "", err
+	}
+
+	s := session{
+		AccessKeyID: "ASIA" + base32.StdEncoding.EncodeToString(id),
+		Secret:      base64.StdEncoding.EncodeToString(secret),
+		Expiration:  timeNow().Add(duration).UTC().Truncate(time.Second),
+		Parent:      parent,
+		RoleARN:     roleARN,
+		SessionName: sessionName,
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return session{}, "", err
+	}
+	aead, err := newGCM(sessionKey(c[parent]))
+	if err != nil {
+		return session{}, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return session{}, "", err
+	}
+	sealed := aead.Seal(nonce, nonce, data, []byte(parent))
+	return s, parent + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openSession returns the session sealed in a session token, which must not
+// have expired.
+func (c credentials) openSession(token string) (session, error) {
+	s := session{}
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return s, errInvalidToken
+	}
+	parent := token[:i]
+	secret, ok := c[parent]
+	if !ok {
+		return s, errInvalidToken
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return s, errInvalidToken
+	}
+	aead, err := newGCM(sessionKey(secret))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return s, errInvalidToken
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(parent))
+	if err != nil || json.Unmarshal(data, &s) != nil {
+		return s, errInvalidToken
+	}
+
+	if !timeNow().Before(s.Expiration) {
+		return s, errExpiredToken
+	}
+	return s, nil
+}
+
+// secret returns the secret access key of accessKeyID, which is either one
+// of the configured access keys or, when a session token is sent, the
+// temporary credentials sealed in the token.
+func (c credentials) secret(accessKeyID, token string) (string, *session, error) {
+	if token == "" {
+		secret, ok := c[accessKeyID]
+		if !ok {
+			return "", nil, errInvalidAccessKeyID
+		}
+		return secret, nil, nil
+	}
+
+	s, err := c.openSession(token)
+	if err != nil {
+		return "", nil, err
+	}
+	if s.AccessKeyID != accessKeyID {
+		return "", nil, errInvalidToken
+	}
+	return s.Secret, &s, nil
+}
+
+// sessionKey returns the key session tokens issued to an access key are
+// sealed with.
+func sessionKey(secret string) []byte {
+	key := sha256.Sum256([]byte("sts:" + secret))
+	return key[:]
+}
+
+func (s session) credentials(token string) stsCredentials {
+	return stsCredentials{
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.Secret,
+		SessionToken:    token,
+		Expiration:      formatISO8601(s.Expiration),
+	}
+}
+
+// roleID returns the unique ID of a role, which is made up from its ARN.
+func roleID(roleARN string) string {
+	sum := sha256.Sum256([]byte(roleARN))
+	return "AROA" + strings.ToUpper(hex.EncodeToString(sum[:8]))
+}
+
+func validationError(member, value string) apiError {
+	err := errValidationError
+	err.Message = "1 validation error detected: Value '" + value + "' at '" + lowerFirst(member) + "' failed to satisfy constraint"
+	return err
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// writeSTSError writes err as an STS error response.
+func writeSTSError(w http.ResponseWriter, err error) {
+	apiErr := apiError{}
+	if !errors.As(err, &apiErr) {
+		apiErr = errInternalError
+	}
+	errType := "Sender"
+	if apiErr.StatusCode >= http.StatusInternalServerError {
+		errType = "Receiver"
+	}
+	writeXML(w, apiErr.StatusCode, stsErrorResponse{
+		Xmlns:     stsXmlns,
+		Error:     stsError{Type: errType, Code: apiErr.Code, Message: apiErr.Message},
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
@@ -0,0 +1,267 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stsClock fixes timeNow for the duration of a test, returning a function
+// which advances it.  Requests signed by stsSigner use the same time.
+func stsClock(t *testing.T) (*time.Time, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	preserveTimeNow := timeNow
+	t.Cleanup(func() {
+		timeNow = preserveTimeNow
+	})
+	timeNow = func() time.Time {
+		return now
+	}
+	return &now, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func stsSigner(now *time.Time, accessKeyID, secret, token string) S3 {
+	return S3{
+		AccessID:     accessKeyID,
+		AWSRegion:    "us-east-1",
+		AMZDate:      now.Format(dateTimeLayout),
+		Secret:       secret,
+		SessionToken: token,
+	}
+}
+
+// stsRequest calls an STS action through s3.auth, signed by signer.
+func stsRequest(t *testing.T, h http.Handler, signer S3, action string, params ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := url.Values{"Action": {action}, "Version": {"2011-06-15"}}
+	for i := 0; i+1 < len(params); i += 2 {
+		form.Set(params[i], params[i+1])
+	}
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:8000/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if signer.Service == "" {
+		signer.Service = stsService
+	}
+	if signer.AccessID != "" {
+		if err := signer.SignRequest(r); err != nil {
+			t.Fatalf("expected err to be nil got %v", err)
+		}
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, r)
+	return res
+}
+
+// s3Request sends a request for target through s3.auth, signed by signer.
+func s3Request(t *testing.T, h http.Handler, signer S3, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "http://localhost:8000"+target, strings.NewReader(body))
+	if err := signer.SignRequest(r); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, r)
+	return res
+}
+
+func TestSTSGetSessionToken(t *testing.T) {
+	now, advance := stsClock(t)
+	setTestCredentials(t)
+	h := Auth(newTestHandler(t, "bucket"))
+	user := stsSigner(now, testAccessKeyID, testSecret, "")
+
+	res := stsRequest(t, h, user, "GetSessionToken", "DurationSeconds", "3600")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	result := getSessionTokenResponse{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	creds := result.Result.Credentials
+	if !strings.HasPrefix(creds.AccessKeyID, "ASIA") || creds.SecretAccessKey == "" || creds.SessionToken == "" {
+		t.Errorf("expected temporary credentials got %+v", creds)
+	}
+	if want := "2024-01-01T13:00:00.000Z"; creds.Expiration != want {
+		t.Errorf("expected expiration to be %s got %s", want, creds.Expiration)
+	}
+
+	session := stsSigner(now, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	if res := s3Request(t, h, session, http.MethodPut, "/bucket/a.txt", "a"); res.Code != http.StatusOK {
+		t.Errorf("expected put status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	res = stsRequest(t, h, session, "GetCallerIdentity")
+	if want := "<Arn>arn:aws:iam::000000000000:user/" + testAccessKeyID + "</Arn>"; !strings.Contains(res.Body.String(), want) {
+		t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+	}
+
+	res = stsRequest(t, h, session, "GetSessionToken")
+	if res.Code != http.StatusForbidden || !strings.Contains(res.Body.String(), "<Code>AccessDenied</Code>") {
+		t.Errorf("expected GetSessionToken with session credentials to be denied got %d: %s", res.Code, res.Body.String())
+	}
+
+	tests := map[string]struct {
+		signer   S3
+		wantCode int
+		wantBody string
+	}{
+		"no token":       {signer: stsSigner(now, creds.AccessKeyID, creds.SecretAccessKey, ""), wantCode: http.StatusForbidden, wantBody: "<Code>InvalidAccessKeyId</Code>"},
+		"tampered token": {signer: stsSigner(now, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken+"A"), wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidToken</Code>"},
+		"other token":    {signer: stsSigner(now, testAccessKeyID, testSecret, creds.SessionToken), wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidToken</Code>"},
+		"wrong secret":   {signer: stsSigner(now, creds.AccessKeyID, testSecret, creds.SessionToken), wantCode: http.StatusForbidden, wantBody: "<Code>SignatureDoesNotMatch</Code>"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := s3Request(t, h, tc.signer, http.MethodGet, "/bucket/a.txt", "")
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+
+	advance(time.Hour)
+	session = stsSigner(now, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	res = s3Request(t, h, session, http.MethodGet, "/bucket/a.txt", "")
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "<Code>ExpiredToken</Code>") {
+		t.Errorf("expected expired credentials to be rejected got %d: %s", res.Code, res.Body.String())
+	}
+	res = stsRequest(t, h, session, "GetCallerIdentity")
+	if !strings.Contains(res.Body.String(), "<ErrorResponse") || !strings.Contains(res.Body.String(), "<Code>ExpiredToken</Code>") {
+		t.Errorf("expected an STS ExpiredToken error got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestSTSAssumeRole(t *testing.T) {
+	now, _ := stsClock(t)
+	setTestCredentials(t)
+	handler := newTestHandler(t, "bucket")
+	h := Auth(handler)
+	user := stsSigner(now, testAccessKeyID, testSecret, "")
+
+	res := stsRequest(t, h, user, "AssumeRole", "RoleArn", "arn:aws:iam::000000000000:role/reader", "RoleSessionName", "qa")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected code to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	result := assumeRoleResponse{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	if want := "arn:aws:sts::000000000000:assumed-role/reader/qa"; result.Result.AssumedRoleUser.Arn != want {
+		t.Errorf("expected assumed role ARN to be %s got %s", want, result.Result.AssumedRoleUser.Arn)
+	}
+	if want := "2024-01-01T13:00:00.000Z"; result.Result.Credentials.Expiration != want {
+		t.Errorf("expected expiration to be %s got %s", want, result.Result.Credentials.Expiration)
+	}
+	creds := result.Result.Credentials
+	role := stsSigner(now, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+
+	res = stsRequest(t, h, role, "GetCallerIdentity")
+	for _, want := range []string{
+		"<Arn>arn:aws:sts::000000000000:assumed-role/reader/qa</Arn>",
+		"<UserId>" + result.Result.AssumedRoleUser.AssumedRoleID + "</UserId>",
+		"<Account>000000000000</Account>",
+	} {
+		if !strings.Contains(res.Body.String(), want) {
+			t.Errorf("expected body to contain %q got %q", want, res.Body.String())
+		}
+	}
+
+	// Bucket policies match the role, and not the user who assumed it.
+	doRequest(handler, http.MethodPut, "/bucket?policy", `{"Statement": {
+		"Effect": "Deny",
+		"Principal": {"AWS": "arn:aws:iam::000000000000:role/reader"},
+		"Action": "s3:PutObject",
+		"Resource": "arn:aws:s3:::bucket/*"
+	}}`)
+	if res := s3Request(t, h, role, http.MethodPut, "/bucket/a.txt", "a"); res.Code != http.StatusForbidden {
+		t.Errorf("expected role put status to be %d got %d", http.StatusForbidden, res.Code)
+	}
+	if res := s3Request(t, h, user, http.MethodPut, "/bucket/a.txt", "a"); res.Code != http.StatusOK {
+		t.Errorf("expected user put status to be %d got %d", http.StatusOK, res.Code)
+	}
+
+	// Credentials of a role chained from another last at most an hour.
+	res = stsRequest(t, h, role, "AssumeRole", "RoleArn", "arn:aws:iam::000000000000:role/writer", "RoleSessionName", "qa", "DurationSeconds", "7200")
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "<Code>ValidationError</Code>") {
+		t.Errorf("expected role chaining longer than an hour to fail got %d: %s", res.Code, res.Body.String())
+	}
+	res = stsRequest(t, h, role, "AssumeRole", "RoleArn", "arn:aws:iam::000000000000:role/writer", "RoleSessionName", "qa")
+	if res.Code != http.StatusOK {
+		t.Errorf("expected role chaining status to be %d got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+}
+
+func TestSTSInvalid(t *testing.T) {
+	now, _ := stsClock(t)
+	setTestCredentials(t)
+	h := Auth(newTestHandler(t, "bucket"))
+	user := stsSigner(now, testAccessKeyID, testSecret, "")
+	s3Signer := user
+	s3Signer.Service = awsService
+
+	tests := map[string]struct {
+		signer   S3
+		action   string
+		params   []string
+		wantCode int
+		wantBody string
+	}{
+		"unsigned":         {action: "GetCallerIdentity", wantCode: http.StatusForbidden, wantBody: "<Code>MissingAuthenticationToken</Code>"},
+		"signed for s3":    {signer: s3Signer, action: "GetCallerIdentity", wantCode: http.StatusBadRequest, wantBody: "<Code>AuthorizationHeaderMalformed</Code>"},
+		"unknown action":   {signer: user, action: "AssumeRoleWithSAML", wantCode: http.StatusBadRequest, wantBody: "<Code>InvalidAction</Code>"},
+		"no role":          {signer: user, action: "AssumeRole", params: []string{"RoleSessionName", "qa"}, wantCode: http.StatusBadRequest, wantBody: "<Code>ValidationError</Code>"},
+		"role ARN":         {signer: user, action: "AssumeRole", params: []string{"RoleArn", "reader", "RoleSessionName", "qa"}, wantCode: http.StatusBadRequest, wantBody: "<Code>ValidationError</Code>"},
+		"session name":     {signer: user, action: "AssumeRole", params: []string{"RoleArn", "arn:aws:iam::000000000000:role/reader", "RoleSessionName", "q a"}, wantCode: http.StatusBadRequest, wantBody: "<Code>ValidationError</Code>"},
+		"short duration":   {signer: user, action: "GetSessionToken", params: []string{"DurationSeconds", "60"}, wantCode: http.StatusBadRequest, wantBody: "<Code>ValidationError</Code>"},
+		"invalid duration": {signer: user, action: "GetSessionToken", params: []string{"DurationSeconds", "hour"}, wantCode: http.StatusBadRequest, wantBody: "<Code>ValidationError</Code>"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := stsRequest(t, h, tc.signer, tc.action, tc.params...)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected code to be %d got %d: %s", tc.wantCode, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), tc.wantBody) {
+				t.Errorf("expected body to contain %q got %q", tc.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestIsSTSRequest(t *testing.T) {
+	tests := map[string]struct {
+		method      string
+		url         string
+		contentType string
+		want        bool
+	}{
+		"form":                {method: http.MethodPost, url: "http://s3.localhost/", contentType: "application/x-www-form-urlencoded", want: true},
+		"form with charset":   {method: http.MethodPost, url: "http://localhost/", contentType: "application/x-www-form-urlencoded; charset=utf-8", want: true},
+		"get":                 {method: http.MethodGet, url: "http://s3.localhost/", contentType: "application/x-www-form-urlencoded", want: false},
+		"multipart":           {method: http.MethodPost, url: "http://s3.localhost/", contentType: "multipart/form-data", want: false},
+		"path style bucket":   {method: http.MethodPost, url: "http://s3.localhost/bucket", contentType: "application/x-www-form-urlencoded", want: false},
+		"virtual hosted root": {method: http.MethodPost, url: "http://bucket.s3.localhost/", contentType: "application/x-www-form-urlencoded", want: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.url, nil)
+			r.Header.Set("Content-Type", tc.contentType)
+			if got := isSTSRequest(r, "s3.localhost"); got != tc.want {
+				t.Errorf("expected isSTSRequest to be %v got %v", tc.want, got)
+			}
+		})
+	}
+}
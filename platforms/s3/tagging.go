@@ -0,0 +1,206 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxObjectTags = 10
+const maxBucketTags = 50
+const maxTagKeyLength = 128
+const maxTagValueLength = 256
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	TagSet  []tag    `xml:"TagSet>Tag"`
+}
+
+type tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// bucketTagging is the state stored for a bucket's tags.
+type bucketTagging struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// newTagging returns tags as a Tagging document, sorted by key.
+func newTagging(tags map[string]string) tagging {
+	t := tagging{Xmlns: xmlns, TagSet: []tag{}}
+	for k, v := range tags {
+		t.TagSet = append(t.TagSet, tag{Key: k, Value: v})
+	}
+	sort.Slice(t.TagSet, func(i, j int) bool { return t.TagSet[i].Key < t.TagSet[j].Key })
+	return t
+}
+
+// readTagging returns the tags of a Tagging document, if there are no more
+// than limit valid tags.
+func readTagging(r io.Reader, limit int) (map[string]string, error) {
+	t := tagging{}
+	if err := xml.NewDecoder(r).Decode(&t); err != nil {
+		return nil, errMalformedXML
+	}
+	io.Copy(io.Discard, r)
+
+	tags := map[string]string{}
+	for _, tag := range t.TagSet {
+		if _, ok := tags[tag.Key]; ok {
+			return nil, errDuplicateTagKeys
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, validTags(tags, limit)
+}
+
+// parseTaggingHeader returns the tags of an x-amz-tagging header, which are
+// encoded as URL query parameters.
+func parseTaggingHeader(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	query, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, errInvalidTaggingHeader
+	}
+
+	tags := map[string]string{}
+	for k, v := range query {
+		if len(v) > 1 {
+			return nil, errDuplicateTagKeys
+		}
+		tags[k] = v[0]
+	}
+	return tags, validTags(tags, maxObjectTags)
+}
+
+// validTags returns the error S3 responds with when tags exceed its limits,
+// or nil if they are valid.
+func validTags(tags map[string]string, limit int) error {
+	if len(tags) > limit {
+		err := errInvalidTag
+		if limit == maxObjectTags {
+			err.Message = "Object tags cannot be greater than " + strconv.Itoa(limit)
+		} else {
+			err.Message = "Bucket tag count cannot be greater than " + strconv.Itoa(limit)
+		}
+		return err
+	}
+
+	for k, v := range tags {
+		err := errInvalidTag
+		switch {
+		case k == "" || utf8.RuneCountInString(k) > maxTagKeyLength:
+			err.Message = "The TagKey you have provided is invalid"
+		case utf8.RuneCountInString(v) > maxTagValueLength:
+			err.Message = "The TagValue you have provided is too long, max " + strconv.Itoa(maxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			err.Message = "Your TagKey cannot be prefixed with aws:"
+		case !tagPattern.MatchString(k) || !tagPattern.MatchString(v):
+			err.Message = "The TagKey or TagValue you have provided contains invalid characters"
+		default:
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+func (h Handler) putObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	tags, err := readTagging(r.Body, maxObjectTags)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+	h.writeObjectTags(w, r, bucket, key, tags, http.StatusOK)
+}
+
+func (h Handler) deleteObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	h.writeObjectTags(w, r, bucket, key, nil, http.StatusNoContent)
+}
+
+func (h Handler) getObjectTagging(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	obj, err := h.findObject(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeVersionError(w, r, err)
+		return
+	}
+
+	if obj.Meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", obj.Meta.VersionID)
+	}
+	writeXML(w, http.StatusOK, newTagging(obj.Meta.Tags))
+}
+
+// writeObjectTags replaces the tags of the requested version of an object,
+// responding with statusCode when they are stored.
+func (h Handler) writeObjectTags(w http.ResponseWriter, r *http.Request, bucket, key string, tags map[string]string, statusCode int) {
+	obj, err := h.findObject(bucket, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeVersionError(w, r, err)
+		return
+	}
+
+	obj.Meta.Tags = tags
+	if err := h.updateMeta(bucket, key, obj); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	if obj.Meta.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", obj.Meta.VersionID)
+	}
+	w.WriteHeader(statusCode)
+}
+
+func (h Handler) putBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	tags, err := readTagging(r.Body, maxBucketTags)
+	if err != nil {
+		io.Copy(io.Discard, r.Body)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if err := h.writeJSON(h.systemPath(bucket, "tagging.json"), bucketTagging{Tags: tags}); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h Handler) getBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	t := bucketTagging{}
+	if err := readJSON(h.systemPath(bucket, "tagging.json"), &t); err != nil {
+		if isNotExist(err) {
+			writeError(w, r, errNoSuchTagSet)
+			return
+		}
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	writeXML(w, http.StatusOK, newTagging(t.Tags))
+}
+
+func (h Handler) deleteBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := os.Remove(h.systemPath(bucket, "tagging.json")); err != nil && !isNotExist(err) {
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
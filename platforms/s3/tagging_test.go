@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// taggingBody returns a Tagging document with the given key value pairs.
+func taggingBody(pairs ...string) string {
+	var b strings.Builder
+	b.WriteString("<Tagging><TagSet>")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fmt.Fprintf(&b, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", pairs[i], pairs[i+1])
+	}
+	b.WriteString("</TagSet></Tagging>")
+	return b.String()
+}
+
+// getTags returns the tags in a GetObjectTagging or GetBucketTagging
+// response as "key=value" strings.
+func getTags(t *testing.T, h Handler, target string) []string {
+	t.Helper()
+	res := doRequest(h, http.MethodGet, target, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected tagging status to be %v got %v", http.StatusOK, res.Code)
+	}
+	result := tagging{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	tags := []string{}
+	for _, tag := range result.TagSet {
+		tags = append(tags, tag.Key+"="+tag.Value)
+	}
+	return tags
+}
+
+func TestObjectTagging(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodPut, "/bucket/key", "data", "X-Amz-Tagging", "project=alpha&stage=raw%20data")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected put status to be %v got %v", http.StatusOK, res.Code)
+	}
+	if diff := cmp.Diff([]string{"project=alpha", "stage=raw data"}, getTags(t, h, "/bucket/key?tagging")); diff != "" {
+		t.Errorf("unexpected tags: %s", diff)
+	}
+	res = doRequest(h, http.MethodHead, "/bucket/key", "")
+	if got := res.Header().Get("X-Amz-Tagging-Count"); got != "2" {
+		t.Errorf("expected tagging count to be %v got %v", "2", got)
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket/key?tagging", taggingBody("expire", "30d"))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected put tagging status to be %v got %v", http.StatusOK, res.Code)
+	}
+	if diff := cmp.Diff([]string{"expire=30d"}, getTags(t, h, "/bucket/key?tagging")); diff != "" {
+		t.Errorf("unexpected tags after put tagging: %s", diff)
+	}
+	res = doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Body.String() != "data" || res.Header().Get("ETag") != `"8d777f385d3dfec8815d20f7496026dc"` {
+		t.Errorf("expected tagging to not change the object got %q %v", res.Body.String(), res.Header().Get("ETag"))
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.Directory, systemDir, "bucket", "meta", "key.json"))
+	if err != nil || !strings.Contains(string(data), `"expire": "30d"`) {
+		t.Errorf("expected tags to be stored with the metadata got %s %v", data, err)
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket/copy", "", "X-Amz-Copy-Source", "/bucket/key")
+	if diff := cmp.Diff([]string{"expire=30d"}, getTags(t, h, "/bucket/copy?tagging")); diff != "" {
+		t.Errorf("unexpected copied tags: %s", diff)
+	}
+	doRequest(h, http.MethodPut, "/bucket/copy", "",
+		"X-Amz-Copy-Source", "/bucket/key",
+		"X-Amz-Tagging-Directive", "REPLACE",
+		"X-Amz-Tagging", "copy=true",
+	)
+	if diff := cmp.Diff([]string{"copy=true"}, getTags(t, h, "/bucket/copy?tagging")); diff != "" {
+		t.Errorf("unexpected replaced tags: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/key?tagging", "")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected delete tagging status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+	if diff := cmp.Diff([]string{}, getTags(t, h, "/bucket/key?tagging")); diff != "" {
+		t.Errorf("unexpected tags after delete tagging: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket/missing?tagging", taggingBody("a", "b"))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected missing object status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestVersionedObjectTagging(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	v1 := doRequest(h, http.MethodPut, "/bucket/key", "one", "X-Amz-Tagging", "v=1").Header().Get("X-Amz-Version-Id")
+	doRequest(h, http.MethodPut, "/bucket/key", "two", "X-Amz-Tagging", "v=2")
+
+	res := doRequest(h, http.MethodPut, "/bucket/key?tagging&versionId="+v1, taggingBody("v", "old"))
+	if res.Header().Get("X-Amz-Version-Id") != v1 {
+		t.Errorf("expected version id to be %v got %v", v1, res.Header().Get("X-Amz-Version-Id"))
+	}
+	if diff := cmp.Diff([]string{"v=old"}, getTags(t, h, "/bucket/key?tagging&versionId="+v1)); diff != "" {
+		t.Errorf("unexpected noncurrent tags: %s", diff)
+	}
+	if diff := cmp.Diff([]string{"v=2"}, getTags(t, h, "/bucket/key?tagging")); diff != "" {
+		t.Errorf("unexpected current tags: %s", diff)
+	}
+}
+
+func TestTagLimits(t *testing.T) {
+	tooMany := []string{}
+	for i := range maxObjectTags + 1 {
+		tooMany = append(tooMany, fmt.Sprintf("k%d", i), "v")
+	}
+
+	tests := map[string]struct {
+		body     string
+		headers  []string
+		wantCode int
+	}{
+		"valid":          {body: taggingBody("a", "b"), wantCode: http.StatusOK},
+		"too many":       {body: taggingBody(tooMany...), wantCode: http.StatusBadRequest},
+		"key too long":   {body: taggingBody(strings.Repeat("k", maxTagKeyLength+1), "v"), wantCode: http.StatusBadRequest},
+		"value too long": {body: taggingBody("k", strings.Repeat("v", maxTagValueLength+1)), wantCode: http.StatusBadRequest},
+		"empty key":      {body: taggingBody("", "v"), wantCode: http.StatusBadRequest},
+		"duplicate key":  {body: taggingBody("k", "1", "k", "2"), wantCode: http.StatusBadRequest},
+		"aws prefix":     {body: taggingBody("aws:k", "v"), wantCode: http.StatusBadRequest},
+		"invalid chars":  {body: taggingBody("k", "v;"), wantCode: http.StatusBadRequest},
+		"malformed":      {body: "<Tagging>", wantCode: http.StatusBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, "bucket")
+			doRequest(h, http.MethodPut, "/bucket/key", "data")
+			res := doRequest(h, http.MethodPut, "/bucket/key?tagging", tc.body)
+			if res.Code != tc.wantCode {
+				t.Errorf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+		})
+	}
+
+	h := newTestHandler(t, "bucket")
+	res := doRequest(h, http.MethodPut, "/bucket/key", "data", "X-Amz-Tagging", "k=1&k=2")
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected duplicate header tags status to be %v got %v", http.StatusBadRequest, res.Code)
+	}
+	res = doRequest(h, http.MethodPut, "/bucket?tagging", taggingBody(tooMany...))
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected bucket to allow %v tags got %v", len(tooMany)/2, res.Code)
+	}
+}
+
+func TestBucketTagging(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodGet, "/bucket?tagging", "")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), "NoSuchTagSet") {
+		t.Errorf("expected missing tag set status to be %v got %v %s", http.StatusNotFound, res.Code, res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodPut, "/bucket?tagging", taggingBody("team", "media", "env", "dev"))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected put status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+	if diff := cmp.Diff([]string{"env=dev", "team=media"}, getTags(t, h, "/bucket?tagging")); diff != "" {
+		t.Errorf("unexpected tags: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket?tagging", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected delete status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+	res = doRequest(h, http.MethodGet, "/bucket?tagging", "")
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected deleted tag set status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+	res = doRequest(h, http.MethodHead, "/bucket", "")
+	if res.Code != http.StatusOK {
+		t.Errorf("expected the bucket to remain got %v", res.Code)
+	}
+}
@@ -0,0 +1,618 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	versioningEnabled   = "Enabled"
+	versioningSuspended = "Suspended"
+)
+
+// nullVersionID is the version ID of objects stored while versioning is
+// suspended, or before it was enabled.
+const nullVersionID = "null"
+
+var versionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// bucketVersioning is the versioning state stored for a bucket.
+type bucketVersioning struct {
+	Status string `json:"status"`
+}
+
+// objectVersion is a noncurrent version of an object, or a delete marker,
+// kept in the versions area of a bucket.
+type objectVersion struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId"`
+	DeleteMarker bool      `json:"deleteMarker,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+	// Archived is the time the version stopped being current, or the
+	// delete marker was created, and orders the versions of a key.
+	Archived time.Time  `json:"archived"`
+	Meta     objectMeta `json:"meta"`
+}
+
+type listVersionsResult struct {
+	XMLName             xml.Name       `xml:"ListVersionsResult"`
+	Xmlns               string         `xml:"xmlns,attr"`
+	Name                string         `xml:"Name"`
+	Prefix              string         `xml:"Prefix"`
+	KeyMarker           string         `xml:"KeyMarker"`
+	VersionIDMarker     string         `xml:"VersionIdMarker"`
+	NextKeyMarker       string         `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string         `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int            `xml:"MaxKeys"`
+	Delimiter           string         `xml:"Delimiter,omitempty"`
+	IsTruncated         bool           `xml:"IsTruncated"`
+	EncodingType        string         `xml:"EncodingType,omitempty"`
+	Versions            []versionInfo  `xml:"Version"`
+	CommonPrefixes      []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// versionInfo is listed as either a Version or a DeleteMarker element,
+// depending on XMLName, so the two are interleaved in key order as they are
+// by S3.
+type versionInfo struct {
+	XMLName      xml.Name
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         *int64 `xml:"Size"`
+	Owner        owner  `xml:"Owner"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+}
+
+// deleteMarkerError is returned when the requested version of an object is
+// a delete marker.
+type deleteMarkerError struct {
+	Marker objectVersion
+}
+
+func (e deleteMarkerError) Error() string {
+	return "s3: version is a delete marker"
+}
+
+func (h Handler) putBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	config := versioningConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	// Once enabled, versioning can only be suspended.
+	if config.Status != versioningEnabled && config.Status != versioningSuspended {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	if err := h.writeJSON(h.systemPath(bucket, "versioning.json"), bucketVersioning{Status: config.Status}); err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h Handler) getBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	writeXML(w, http.StatusOK, versioningConfiguration{
+		Xmlns:  xmlns,
+		Status: h.versioningStatus(bucket),
+	})
+}
+
+// versioningStatus returns Enabled or Suspended, or an empty string if
+// versioning has never been enabled for bucket.
+func (h Handler) versioningStatus(bucket string) string {
+	v := bucketVersioning{}
+	readJSON(h.systemPath(bucket, "versioning.json"), &v)
+	return v.Status
+}
+
+// versionsPath returns the path in the versions area for key.  Keys are
+// hashed so each has its own directory, regardless of its length or the
+// keys it is a prefix of.
+func (h Handler) versionsPath(bucket, key string, elem ...string) string {
+	sum := sha256.Sum256([]byte(key))
+	return h.systemPath(append([]string{bucket, "versions", hex.EncodeToString(sum[:])}, elem...)...)
+}
+
+func newVersionID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func validVersionID(versionID string) bool {
+	return versionID == nullVersionID || versionIDPattern.MatchString(versionID)
+}
+
+// newVersion prepares for an object to be replaced, preserving its current
+// version when the bucket is versioned, and returns the version ID of the
+// object replacing it.  The version ID is empty for unversioned buckets.
+// Folder objects are never versioned.
+func (h Handler) newVersion(bucket, key string) (string, error) {
+	if isFolderKey(key) {
+		return "", nil
+	}
+
+	switch h.versioningStatus(bucket) {
+	case versioningEnabled:
+		if err := h.archiveObject(bucket, key, true); err != nil {
+			return "", err
+		}
+		return newVersionID(), nil
+	case versioningSuspended:
+		if err := h.archiveObject(bucket, key, false); err != nil {
+			return "", err
+		}
+		if _, err := h.removeVersion(bucket, key, nullVersionID); err != nil && !errors.Is(err, errNoSuchVersion) {
+			return "", err
+		}
+		return nullVersionID, nil
+	}
+
+	return "", nil
+}
+
+// archiveObject copies the current version of an object into the versions
+// area.  The null version is only copied when keepNull is true, otherwise
+// it is left to be replaced.
+//
+// The file is hard linked so the current version stays in place until it
+// is replaced, should that fail the copy is hidden by the current version
+// with the same ID.
+func (h Handler) archiveObject(bucket, key string, keepNull bool) error {
+	obj, err := h.currentObject(bucket, key)
+	if err != nil {
+		if isNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	v := objectVersion{
+		Key:          key,
+		VersionID:    obj.Meta.versionID(),
+		LastModified: obj.Info.ModTime().UTC(),
+		Archived:     h.archiveTime(bucket, key),
+		Meta:         obj.Meta,
+	}
+	if v.VersionID == nullVersionID && !keepNull {
+		return nil
+	}
+
+	target := h.versionsPath(bucket, key, v.VersionID)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	if err := os.Link(obj.Name, target); err != nil {
+		return err
+	}
+
+	return h.writeJSON(target+".json", v)
+}
+
+// archiveTime returns the time a version of key is archived at, which is
+// after every version already archived so they stay ordered when the clock
+// does not advance between them.
+func (h Handler) archiveTime(bucket, key string) time.Time {
+	now := timeNow().UTC()
+	if versions, err := h.readVersions(bucket, key); err == nil && len(versions) > 0 && !now.After(versions[0].Archived) {
+		return versions[0].Archived.Add(time.Nanosecond)
+	}
+	return now
+}
+
+// createDeleteMarker replaces the current version of an object in a
+// versioned bucket with a delete marker.
+func (h Handler) createDeleteMarker(bucket, key string) (objectVersion, error) {
+	versionID, err := h.newVersion(bucket, key)
+	if err != nil {
+		return objectVersion{}, err
+	}
+
+	if err := h.removeObject(bucket, key); err != nil {
+		return objectVersion{}, err
+	}
+
+	marker := objectVersion{
+		Key:          key,
+		VersionID:    versionID,
+		DeleteMarker: true,
+		LastModified: timeNow().UTC(),
+		Archived:     h.archiveTime(bucket, key),
+	}
+	return marker, h.writeJSON(h.versionsPath(bucket, key, versionID+".json"), marker)
+}
+
+// deleteObjectVersion permanently deletes a version of an object, or a
+// delete marker.
+func (h Handler) deleteObjectVersion(bucket, key, versionID string) (deletedObject, error) {
+	if !validVersionID(versionID) {
+		return deletedObject{}, errInvalidVersionID
+	}
+	deleted := deletedObject{VersionID: versionID}
+
+	if obj, err := h.currentObject(bucket, key); err == nil && obj.Meta.versionID() == versionID {
+		if err := h.removeObject(bucket, key); err != nil {
+			return deletedObject{}, err
+		}
+	}
+
+	v, err := h.removeVersion(bucket, key, versionID)
+	if err != nil && !errors.Is(err, errNoSuchVersion) {
+		return deletedObject{}, err
+	}
+	deleted.DeleteMarker = err == nil && v.DeleteMarker
+
+	return deleted, h.promoteVersion(bucket, key)
+}
+
+// promoteVersion makes the newest version in the versions area current
+// again when an object has no current version.  Nothing is promoted when
+// the newest version is a delete marker.
+func (h Handler) promoteVersion(bucket, key string) error {
+	if _, err := h.statObject(bucket, key); err == nil {
+		return nil
+	}
+
+	versions, err := h.readVersions(bucket, key)
+	if err != nil || len(versions) == 0 || versions[0].DeleteMarker {
+		return err
+	}
+	v := versions[0]
+
+	target := h.objectPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(h.versionsPath(bucket, key, v.VersionID), target); err != nil {
+		return err
+	}
+	if err := h.writeMeta(bucket, key, v.Meta); err != nil {
+		return err
+	}
+
+	_, err = h.removeVersion(bucket, key, v.VersionID)
+	return err
+}
+
+// findObject returns the requested version of an object, or the current
+// version when versionID is empty.
+func (h Handler) findObject(bucket, key, versionID string) (objectFile, error) {
+	current, err := h.currentObject(bucket, key)
+	if err != nil && !isNotExist(err) {
+		return objectFile{}, err
+	}
+	exists := err == nil
+
+	if versionID == "" {
+		if exists {
+			return current, nil
+		}
+		versions, err := h.readVersions(bucket, key)
+		if err == nil && len(versions) > 0 && versions[0].DeleteMarker {
+			return objectFile{}, deleteMarkerError{Marker: versions[0]}
+		}
+		return objectFile{}, os.ErrNotExist
+	}
+
+	if !validVersionID(versionID) {
+		return objectFile{}, errInvalidVersionID
+	}
+	if exists && current.Meta.versionID() == versionID {
+		return current, nil
+	}
+
+	v, err := h.readVersion(bucket, key, versionID)
+	if err != nil {
+		return objectFile{}, err
+	}
+	if v.DeleteMarker {
+		return objectFile{}, deleteMarkerError{Marker: v}
+	}
+
+	name := h.versionsPath(bucket, key, v.VersionID)
+	info, err := os.Stat(name)
+	if err != nil {
+		if isNotExist(err) {
+			return objectFile{}, errNoSuchVersion
+		}
+		return objectFile{}, err
+	}
+	return objectFile{Name: name, Info: info, Meta: v.Meta}, nil
+}
+
+// updateMeta stores the metadata of obj, a version of an object returned by
+// findObject, without changing its data.
+func (h Handler) updateMeta(bucket, key string, obj objectFile) error {
+	if obj.Name == h.objectPath(bucket, key) {
+		return h.writeMeta(bucket, key, obj.Meta)
+	}
+
+	v, err := h.readVersion(bucket, key, obj.Meta.versionID())
+	if err != nil {
+		return err
+	}
+	v.Meta = obj.Meta
+	return h.writeJSON(h.versionsPath(bucket, key, v.VersionID+".json"), v)
+}
+
+// writeVersionError writes the response for an error returned by
+// findObject.
+func writeVersionError(w http.ResponseWriter, r *http.Request, err error) {
+	marker := deleteMarkerError{}
+	apiErr := apiError{}
+	switch {
+	case errors.As(err, &marker):
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		w.Header().Set("X-Amz-Version-Id", marker.Marker.VersionID)
+		if r.URL.Query().Has("versionId") {
+			w.Header().Set("Last-Modified", marker.Marker.LastModified.Format(http.TimeFormat))
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		writeError(w, r, errNoSuchKey)
+	case isNotExist(err):
+		writeError(w, r, errNoSuchKey)
+	case errors.As(err, &apiErr):
+		writeError(w, r, apiErr)
+	default:
+		writeError(w, r, errInternalError)
+	}
+}
+
+// readVersion returns a version of key from the versions area.
+func (h Handler) readVersion(bucket, key, versionID string) (objectVersion, error) {
+	v := objectVersion{}
+	if !validVersionID(versionID) {
+		return v, errNoSuchVersion
+	}
+
+	if err := readJSON(h.versionsPath(bucket, key, versionID+".json"), &v); err != nil {
+		if isNotExist(err) {
+			return v, errNoSuchVersion
+		}
+		return v, err
+	}
+
+	if v.Key != key {
+		return v, errNoSuchVersion
+	}
+	return v, nil
+}
+
+// readVersions returns the versions of key in the versions area, newest
+// first.
+func (h Handler) readVersions(bucket, key string) ([]objectVersion, error) {
+	versions, err := readVersionDir(h.versionsPath(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+
+	matching := versions[:0]
+	for _, v := range versions {
+		if v.Key == key {
+			matching = append(matching, v)
+		}
+	}
+	return matching, nil
+}
+
+// readVersionDir returns the versions stored in dir, newest first.
+func readVersionDir(dir string) ([]objectVersion, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []objectVersion{}
+	for _, m := range matches {
+		v := objectVersion{}
+		if err := readJSON(m, &v); err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Archived.After(versions[j].Archived)
+	})
+	return versions, nil
+}
+
+// removeVersion removes a version from the versions area.
+func (h Handler) removeVersion(bucket, key, versionID string) (objectVersion, error) {
+	v, err := h.readVersion(bucket, key, versionID)
+	if err != nil {
+		return v, err
+	}
+
+	os.Remove(h.versionsPath(bucket, key, versionID))
+	if err := os.Remove(h.versionsPath(bucket, key, versionID+".json")); err != nil {
+		return v, err
+	}
+	removeEmptyParents(h.systemPath(bucket), h.versionsPath(bucket, key))
+	return v, nil
+}
+
+// walkVersions returns every version of the objects in bucket beginning
+// with prefix, sorted by key and then newest first.
+func (h Handler) walkVersions(bucket, prefix string) ([]objectVersion, error) {
+	objects, err := h.walkObjects(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string][]objectVersion{}
+	for _, o := range objects {
+		meta, err := h.loadMeta(bucket, o.Key, o.Info)
+		if err != nil {
+			continue
+		}
+		meta.Size = objectSize(o.Info)
+		byKey[o.Key] = []objectVersion{{
+			Key:          o.Key,
+			VersionID:    meta.versionID(),
+			LastModified: o.Info.ModTime().UTC(),
+			Meta:         meta,
+		}}
+	}
+
+	entries, err := os.ReadDir(h.systemPath(bucket, "versions"))
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		versions, err := readVersionDir(h.systemPath(bucket, "versions", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if !strings.HasPrefix(v.Key, prefix) {
+				continue
+			}
+			// The current version hides the copy left by a failed replace.
+			if current, ok := byKey[v.Key]; ok && current[0].VersionID == v.VersionID {
+				continue
+			}
+			byKey[v.Key] = append(byKey[v.Key], v)
+		}
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	versions := []objectVersion{}
+	for _, key := range keys {
+		versions = append(versions, byKey[key]...)
+	}
+	return versions, nil
+}
+
+func (h Handler) listObjectVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	params, ok := parseListParams(query)
+	if !ok {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	if versionIDMarker != "" && keyMarker == "" {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	versions, err := h.walkVersions(bucket, params.Prefix)
+	if err != nil {
+		writeError(w, r, errInternalError)
+		return
+	}
+
+	result := listVersionsResult{
+		Xmlns:           xmlns,
+		Name:            bucket,
+		Prefix:          encodeKey(params.Prefix, params.EncodingType),
+		KeyMarker:       encodeKey(keyMarker, params.EncodingType),
+		VersionIDMarker: versionIDMarker,
+		MaxKeys:         params.MaxKeys,
+		Delimiter:       encodeKey(params.Delimiter, params.EncodingType),
+		EncodingType:    params.EncodingType,
+	}
+
+	skipPrefix := ""
+	if keyMarker != "" && commonPrefixOf(keyMarker, params.Prefix, params.Delimiter) == keyMarker {
+		skipPrefix = keyMarker
+	}
+
+	// With a version-id-marker, versions of the key-marker are skipped
+	// until after the marked version.
+	markerFound := versionIDMarker == ""
+	lastKey, lastVersionID := "", ""
+	count := 0
+	for i, v := range versions {
+		isLatest := i == 0 || versions[i-1].Key != v.Key
+
+		if v.Key < keyMarker || (skipPrefix != "" && strings.HasPrefix(v.Key, skipPrefix)) {
+			continue
+		}
+		if v.Key == keyMarker && !markerFound {
+			markerFound = v.VersionID == versionIDMarker
+			continue
+		}
+		if v.Key == keyMarker && versionIDMarker == "" {
+			continue
+		}
+
+		cp := commonPrefixOf(v.Key, params.Prefix, params.Delimiter)
+		if cp != "" && cp == lastKey {
+			continue
+		}
+
+		if count == params.MaxKeys {
+			result.IsTruncated = true
+			result.NextKeyMarker = encodeKey(lastKey, params.EncodingType)
+			result.NextVersionIDMarker = lastVersionID
+			break
+		}
+		count++
+
+		if cp != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encodeKey(cp, params.EncodingType)})
+			lastKey, lastVersionID = cp, ""
+			continue
+		}
+		result.Versions = append(result.Versions, newVersionInfo(v, isLatest, params.EncodingType))
+		lastKey, lastVersionID = v.Key, v.VersionID
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func newVersionInfo(v objectVersion, isLatest bool, encodingType string) versionInfo {
+	info := versionInfo{
+		XMLName:      xml.Name{Local: "Version"},
+		Key:          encodeKey(v.Key, encodingType),
+		VersionID:    v.VersionID,
+		IsLatest:     isLatest,
+		LastModified: formatISO8601(v.LastModified),
+		Owner:        owner{ID: ownerID, DisplayName: ownerDisplayName},
+	}
+	if v.DeleteMarker {
+		info.XMLName.Local = "DeleteMarker"
+		return info
+	}
+
+	size := v.Meta.dataSize(v.Meta.Size)
+	info.ETag = quoteETag(v.Meta.ETag)
+	info.Size = &size
+	info.StorageClass = "STANDARD"
+	return info
+}
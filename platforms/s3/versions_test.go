@@ -0,0 +1,294 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const enableVersioning = `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`
+const suspendVersioning = `<VersioningConfiguration><Status>Suspended</Status></VersioningConfiguration>`
+
+// listVersions returns the versions and delete markers from a
+// ListObjectVersions response as "<element> <key> <version id> <is latest>"
+// lines, in the order they were listed.
+func listVersions(t *testing.T, h Handler, target string) (listVersionsResult, []string) {
+	t.Helper()
+	res := doRequest(h, http.MethodGet, target, "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected list status to be %v got %v", http.StatusOK, res.Code)
+	}
+
+	result := listVersionsResult{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &result); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	entries := struct {
+		Entries []struct {
+			XMLName   xml.Name
+			Key       string `xml:"Key"`
+			VersionID string `xml:"VersionId"`
+			IsLatest  bool   `xml:"IsLatest"`
+		} `xml:",any"`
+	}{}
+	if err := xml.Unmarshal(res.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+
+	lines := []string{}
+	for _, e := range entries.Entries {
+		if e.XMLName.Local != "Version" && e.XMLName.Local != "DeleteMarker" {
+			continue
+		}
+		latest := ""
+		if e.IsLatest {
+			latest = " latest"
+		}
+		lines = append(lines, e.XMLName.Local+" "+e.Key+" "+e.VersionID+latest)
+	}
+	return result, lines
+}
+
+func TestBucketVersioningConfiguration(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+
+	res := doRequest(h, http.MethodGet, "/bucket?versioning", "")
+	if strings.Contains(res.Body.String(), "<Status>") {
+		t.Errorf("expected no status before versioning is enabled got %s", res.Body.String())
+	}
+
+	tests := map[string]struct {
+		body     string
+		wantCode int
+		want     string
+	}{
+		"enabled":   {body: enableVersioning, wantCode: http.StatusOK, want: "<Status>Enabled</Status>"},
+		"suspended": {body: suspendVersioning, wantCode: http.StatusOK, want: "<Status>Suspended</Status>"},
+		"invalid":   {body: `<VersioningConfiguration><Status>Off</Status></VersioningConfiguration>`, wantCode: http.StatusBadRequest},
+		"malformed": {body: `<VersioningConfiguration>`, wantCode: http.StatusBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := doRequest(h, http.MethodPut, "/bucket?versioning", tc.body)
+			if res.Code != tc.wantCode {
+				t.Fatalf("expected status to be %v got %v", tc.wantCode, res.Code)
+			}
+			res = doRequest(h, http.MethodGet, "/bucket?versioning", "")
+			if !strings.Contains(res.Body.String(), tc.want) {
+				t.Errorf("expected body to contain %v got %v", tc.want, res.Body.String())
+			}
+		})
+	}
+
+	res = doRequest(h, http.MethodPut, "/missing?versioning", enableVersioning)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected missing bucket status to be %v got %v", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestVersionedObject(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+
+	v1 := doRequest(h, http.MethodPut, "/bucket/key", "one").Header().Get("X-Amz-Version-Id")
+	v2 := doRequest(h, http.MethodPut, "/bucket/key", "two").Header().Get("X-Amz-Version-Id")
+	if v1 == "" || v2 == "" || v1 == v2 {
+		t.Fatalf("expected distinct version ids got %q and %q", v1, v2)
+	}
+
+	res := doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Body.String() != "two" || res.Header().Get("X-Amz-Version-Id") != v2 {
+		t.Errorf("expected current version %v got %q %v", v2, res.Body.String(), res.Header().Get("X-Amz-Version-Id"))
+	}
+	res = doRequest(h, http.MethodGet, "/bucket/key?versionId="+v1, "")
+	if res.Body.String() != "one" {
+		t.Errorf("expected first version body got %q", res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/key", "")
+	marker := res.Header().Get("X-Amz-Version-Id")
+	if res.Code != http.StatusNoContent || res.Header().Get("X-Amz-Delete-Marker") != "true" {
+		t.Fatalf("expected delete to create a delete marker got %v %v", res.Code, res.Header())
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Code != http.StatusNotFound || res.Header().Get("X-Amz-Delete-Marker") != "true" {
+		t.Errorf("expected deleted get to be %v with a delete marker got %v %v", http.StatusNotFound, res.Code, res.Header())
+	}
+	res = doRequest(h, http.MethodHead, "/bucket/key?versionId="+marker, "")
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected delete marker head status to be %v got %v", http.StatusMethodNotAllowed, res.Code)
+	}
+	res = doRequest(h, http.MethodGet, "/bucket/key?versionId="+v2, "")
+	if res.Body.String() != "two" {
+		t.Errorf("expected noncurrent version body got %q", res.Body.String())
+	}
+
+	_, keys, _ := listKeys(t, h, "/bucket?list-type=2")
+	if diff := cmp.Diff([]string{}, keys); diff != "" {
+		t.Errorf("expected deleted object to not be listed: %s", diff)
+	}
+
+	_, versions := listVersions(t, h, "/bucket?versions")
+	want := []string{
+		"DeleteMarker key " + marker + " latest",
+		"Version key " + v2,
+		"Version key " + v1,
+	}
+	if diff := cmp.Diff(want, versions); diff != "" {
+		t.Errorf("unexpected versions after delete: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket", "")
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected delete bucket with versions status to be %v got %v", http.StatusConflict, res.Code)
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/key?versionId="+marker, "")
+	if res.Code != http.StatusNoContent || res.Header().Get("X-Amz-Delete-Marker") != "true" {
+		t.Errorf("expected delete marker removal got %v %v", res.Code, res.Header())
+	}
+	res = doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Body.String() != "two" {
+		t.Errorf("expected removing the delete marker to restore %q got %q", "two", res.Body.String())
+	}
+
+	doRequest(h, http.MethodDelete, "/bucket/key?versionId="+v2, "")
+	res = doRequest(h, http.MethodGet, "/bucket/key", "")
+	if res.Body.String() != "one" || res.Header().Get("X-Amz-Version-Id") != v1 {
+		t.Errorf("expected deleting the current version to restore %v got %q", v1, res.Body.String())
+	}
+
+	doRequest(h, http.MethodDelete, "/bucket/key?versionId="+v1, "")
+	res = doRequest(h, http.MethodDelete, "/bucket", "")
+	if res.Code != http.StatusNoContent {
+		t.Errorf("expected delete bucket status to be %v got %v", http.StatusNoContent, res.Code)
+	}
+}
+
+func TestSuspendedVersioning(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	os.WriteFile(filepath.Join(h.Directory, "bucket", "key"), []byte("unversioned"), 0644)
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+
+	v1 := doRequest(h, http.MethodPut, "/bucket/key", "one").Header().Get("X-Amz-Version-Id")
+
+	doRequest(h, http.MethodPut, "/bucket?versioning", suspendVersioning)
+	res := doRequest(h, http.MethodPut, "/bucket/key", "two")
+	if got := res.Header().Get("X-Amz-Version-Id"); got != nullVersionID {
+		t.Errorf("expected suspended version id to be %v got %v", nullVersionID, got)
+	}
+	doRequest(h, http.MethodPut, "/bucket/key", "three")
+
+	_, versions := listVersions(t, h, "/bucket?versions")
+	want := []string{
+		"Version key null latest",
+		"Version key " + v1,
+	}
+	if diff := cmp.Diff(want, versions); diff != "" {
+		t.Errorf("unexpected versions: %s", diff)
+	}
+
+	res = doRequest(h, http.MethodGet, "/bucket/key?versionId=null", "")
+	if res.Body.String() != "three" {
+		t.Errorf("expected null version body to be %q got %q", "three", res.Body.String())
+	}
+
+	res = doRequest(h, http.MethodDelete, "/bucket/key", "")
+	if got := res.Header().Get("X-Amz-Version-Id"); got != nullVersionID {
+		t.Errorf("expected delete marker version id to be %v got %v", nullVersionID, got)
+	}
+	_, versions = listVersions(t, h, "/bucket?versions")
+	want = []string{
+		"DeleteMarker key null latest",
+		"Version key " + v1,
+	}
+	if diff := cmp.Diff(want, versions); diff != "" {
+		t.Errorf("unexpected versions after delete: %s", diff)
+	}
+}
+
+func TestListObjectVersionsPagination(t *testing.T) {
+	h := newTestHandler(t, "bucket")
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+
+	a1 := doRequest(h, http.MethodPut, "/bucket/a", "1").Header().Get("X-Amz-Version-Id")
+	a2 := doRequest(h, http.MethodPut, "/bucket/a", "2").Header().Get("X-Amz-Version-Id")
+	b1 := doRequest(h, http.MethodPut, "/bucket/b", "1").Header().Get("X-Amz-Version-Id")
+	doRequest(h, http.MethodPut, "/bucket/dir/c", "1")
+	doRequest(h, http.MethodPut, "/bucket/dir/d", "1")
+
+	got := []string{}
+	target := "/bucket?versions&delimiter=/&max-keys=2"
+	for range 5 {
+		result, versions := listVersions(t, h, target)
+		got = append(got, versions...)
+		for _, p := range result.CommonPrefixes {
+			got = append(got, "CommonPrefix "+p.Prefix)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		target = "/bucket?versions&delimiter=/&max-keys=2&key-marker=" + result.NextKeyMarker + "&version-id-marker=" + result.NextVersionIDMarker
+	}
+
+	want := []string{
+		"Version a " + a2 + " latest",
+		"Version a " + a1,
+		"Version b " + b1 + " latest",
+		"CommonPrefix dir/",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected versions: %s", diff)
+	}
+}
+
+func TestVersionsArchivedTime(t *testing.T) {
+	setTimeNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	h := newTestHandler(t, "bucket")
+	h.Clock = &Clock{}
+	doRequest(h, http.MethodPut, "/bucket?versioning", enableVersioning)
+	doRequest(h, http.MethodPut, "/bucket?lifecycle", `<LifecycleConfiguration>
+		<Rule>
+			<Status>Enabled</Status>
+			<Filter></Filter>
+			<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration>
+		</Rule>
+	</LifecycleConfiguration>`)
+
+	ids := []string{}
+	for _, body := range []string{"1", "2", "3"} {
+		res := doRequest(h, http.MethodPut, "/bucket/key", body)
+		ids = append(ids, res.Header().Get("X-Amz-Version-Id"))
+	}
+
+	// Versions archived while the clock does not advance keep their order.
+	want := []string{
+		"Version key " + ids[2] + " latest",
+		"Version key " + ids[1],
+		"Version key " + ids[0],
+	}
+	if _, lines := listVersions(t, h, "/bucket?versions"); !cmp.Equal(want, lines) {
+		t.Errorf("expected versions to be %v got %v", want, lines)
+	}
+
+	// Noncurrent days are counted on the same clock versions are archived
+	// with.
+	h.Clock.Advance(48 * time.Hour)
+	if err := h.Sweep(); err != nil {
+		t.Fatalf("expected err to be nil got %v", err)
+	}
+	want = want[:1]
+	if _, lines := listVersions(t, h, "/bucket?versions"); !cmp.Equal(want, lines) {
+		t.Errorf("expected versions to be %v got %v", want, lines)
+	}
+}
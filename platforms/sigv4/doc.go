@@ -0,0 +1,14 @@
+/*
+Package sigv4 implements a reverse proxy which signs the requests it
+forwards with AWS Signature Version 4, much like aws-sigv4-proxy.  Tools
+which can not sign requests can then talk to an S3 compatible store, or any
+other service requiring SigV4, through it.
+
+The upstream, the service and region requests are signed for, and the
+credentials they are signed with are configured with the HH_SIGV4_* env
+variables, the credentials defaulting to the standard AWS_* env variables.
+Any signature sent by the client is replaced.  Requests to s3 are signed
+with an UNSIGNED-PAYLOAD so bodies are streamed, while bodies sent to other
+services are read to sign their hash.
+*/
+package sigv4
@@ -0,0 +1,81 @@
+package sigv4
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/hurricanerix/http-helper/platforms/s3"
+)
+
+// clientHeaders are the headers of a signature sent by the client, which are
+// removed before the request is signed again.
+var clientHeaders = []string{
+	"Authorization",
+	"X-Amz-Content-Sha256",
+	"X-Amz-Date",
+	"X-Amz-Security-Token",
+}
+
+// Handler forwards requests to Upstream, signed for Service and Region with
+// the configured credentials.
+type Handler struct {
+	Upstream     *url.URL
+	Service      string
+	Region       string
+	AccessKeyID  string
+	Secret       string
+	SessionToken string
+	// Transport sends the signed requests, http.DefaultTransport when nil.
+	Transport http.RoundTripper
+}
+
+// ServeHTTP forwards r to the upstream, responding with a 502 Bad Gateway
+// when it can not be signed or sent.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	next := h.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(h.Upstream)
+		},
+		Transport: signingTransport{
+			signer: s3.S3{
+				AccessID:     h.AccessKeyID,
+				AWSRegion:    h.Region,
+				Secret:       h.Secret,
+				SessionToken: h.SessionToken,
+				Service:      h.Service,
+			},
+			next: next,
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// signingTransport signs each request before sending it with next.
+type signingTransport struct {
+	signer s3.S3
+	next   http.RoundTripper
+}
+
+func (t signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	for _, name := range clientHeaders {
+		r.Header.Del(name)
+	}
+	if t.signer.Service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+
+	if err := t.signer.SignRequest(r); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(r)
+}